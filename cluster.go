@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/urfave/cli/v2"
+	"k8s.io/client-go/rest"
+)
+
+const defaultClusterID = "default"
+
+type ClusterInfo struct {
+	ID       string `json:"id"`
+	KubeHost string `json:"kubeHost"`
+	Context  string `json:"context"`
+	State    bool   `json:"state"`
+}
+
+type ClusterRegistry struct {
+	CliContext *cli.Context
+	Clusters   map[string]*APIResource
+	// SessionID and Identity are the browser session and the proxy user of
+	// the session, every cluster of the session impersonates the user.
+	SessionID string
+	Identity  *rest.ImpersonationConfig
+	Lock      sync.RWMutex
+}
+
+func clusterIDOrDefault(clusterID string) string {
+	if clusterID == "" {
+		return defaultClusterID
+	}
+	return clusterID
+}
+
+func (cr *ClusterRegistry) Get(clusterID string) *APIResource {
+	cr.Lock.RLock()
+	defer cr.Lock.RUnlock()
+	return cr.Clusters[clusterIDOrDefault(clusterID)]
+}
+
+func (cr *ClusterRegistry) Set(clusterID string, ar *APIResource) {
+	cr.Lock.Lock()
+	defer cr.Lock.Unlock()
+	ar.ClusterID = clusterIDOrDefault(clusterID)
+	cr.Clusters[ar.ClusterID] = ar
+}
+
+func (cr *ClusterRegistry) Delete(clusterID string) {
+	cr.Lock.Lock()
+	defer cr.Lock.Unlock()
+	delete(cr.Clusters, clusterIDOrDefault(clusterID))
+}
+
+// Resolve returns the cluster for the given ID only if it is authenticated.
+func (cr *ClusterRegistry) Resolve(clusterID string) *APIResource {
+	ar := cr.Get(clusterID)
+	if ar == nil || (!ar.AuthState && ar.Config == nil) {
+		return nil
+	}
+	return ar
+}
+
+func (cr *ClusterRegistry) Authenticated() bool {
+	cr.Lock.RLock()
+	defer cr.Lock.RUnlock()
+	for _, ar := range cr.Clusters {
+		if ar.AuthState || ar.Config != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (cr *ClusterRegistry) List() []ClusterInfo {
+	cr.Lock.RLock()
+	defer cr.Lock.RUnlock()
+
+	clusterList := []ClusterInfo{}
+	for id, ar := range cr.Clusters {
+		ci := ClusterInfo{ID: id, State: ar.AuthState}
+		if ar.Config != nil {
+			ci.KubeHost = ar.Config.Host
+		}
+		if ar.AuthRequest != nil {
+			ci.Context = ar.AuthRequest.Context
+		}
+		clusterList = append(clusterList, ci)
+	}
+
+	sort.Slice(clusterList, func(i, j int) bool { return clusterList[i].ID < clusterList[j].ID })
+
+	return clusterList
+}
+
+// copyAPIResource copies the cluster for another browser session. The auth
+// request, the rest.Config and the helm settings are per session, the
+// clients are safe to share.
+func copyAPIResource(ar *APIResource) (*APIResource, error) {
+	arCopy := *ar
+	if ar.AuthRequest != nil {
+		authReq := *ar.AuthRequest
+		arCopy.AuthRequest = &authReq
+	}
+	if ar.SSAR != nil {
+		arCopy.SSAR = ar.SSAR.DeepCopy()
+	}
+	if ar.Config == nil {
+		arCopy.Helm = nil
+		return &arCopy, nil
+	}
+
+	arCopy.Config = rest.CopyConfig(ar.Config)
+	hac, hes, err := initHelm(&arCopy)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize helm client: %s", err)
+	}
+	arCopy.Helm = &Helm{ActionConfig: hac, EnvSettings: hes}
+
+	return &arCopy, nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+const watchRetryInterval = time.Second
+
+var WatchEventType = struct {
+	sync,
+	bookmark string
+}{
+	sync:     "SYNC",
+	bookmark: "BOOKMARK",
+}
+
+var errWatchExpired = errors.New("watch resource version expired")
+
+// DataWatchEvent is sent for every event of a running watch op.
+// SYNC carries a full list and is sent when the watch starts without a
+// resourceVersion or after the server answered with 410 Gone, the other types
+// mirror the kubernetes watch event types (ADDED, MODIFIED, DELETED, BOOKMARK).
+type DataWatchEvent struct {
+	Type            string          `json:"type"`
+	Object          json.RawMessage `json:"object,omitempty"`
+	ResourceVersion string          `json:"resourceVersion"`
+}
+
+type DataWatch struct {
+	cancel context.CancelFunc
+}
+
+type DataWatchMap struct {
+	Watches map[string]*DataWatch
+	Lock    sync.Mutex
+}
+
+func (dwm *DataWatchMap) Set(opID string, dw *DataWatch) {
+	dwm.Lock.Lock()
+	defer dwm.Lock.Unlock()
+	if prev, ok := dwm.Watches[opID]; ok {
+		prev.cancel()
+	}
+	dwm.Watches[opID] = dw
+}
+
+func (dwm *DataWatchMap) Stop(opID string) bool {
+	dwm.Lock.Lock()
+	defer dwm.Lock.Unlock()
+	dw, ok := dwm.Watches[opID]
+	if !ok {
+		return false
+	}
+	dw.cancel()
+	delete(dwm.Watches, opID)
+	return true
+}
+
+func (dwm *DataWatchMap) Delete(opID string, dw *DataWatch) {
+	dwm.Lock.Lock()
+	defer dwm.Lock.Unlock()
+	if dwm.Watches[opID] == dw {
+		delete(dwm.Watches, opID)
+	}
+}
+
+func (dwm *DataWatchMap) StopAll() {
+	if dwm == nil {
+		return
+	}
+	dwm.Lock.Lock()
+	defer dwm.Lock.Unlock()
+	for opID, dw := range dwm.Watches {
+		dw.cancel()
+		delete(dwm.Watches, opID)
+	}
+}
+
+func (ds DataStream) kubeWatchResource() (ListResource, error) {
+	var lr ListResource
+	lr.GVR = schema.GroupVersionResource{
+		Group:    ds.recvMsg.Op.Request.KubeGVRK.Group,
+		Version:  ds.recvMsg.Op.Request.KubeGVRK.Version,
+		Resource: ds.recvMsg.Op.Request.KubeGVRK.Resource,
+	}
+	lr.Namespace = ds.recvMsg.Op.Request.Namespace
+	if !ds.recvMsg.Op.Request.KubeGVRK.IsNamespaced {
+		lr.Namespace = ""
+	}
+
+	sel, err := fields.ParseSelector(ds.recvMsg.Op.Request.ResourceOptions.FieldSelector)
+	if err != nil {
+		return lr, err
+	}
+
+	lr.Options = metav1.ListOptions{
+		FieldSelector: sel.String(),
+		LabelSelector: ds.recvMsg.Op.Request.ResourceOptions.LabelSelector,
+	}
+
+	return lr, nil
+}
+
+func (ds DataStream) kubeWatch(ctx context.Context, send func(DataWatchEvent) error) error {
+	lr, err := ds.kubeWatchResource()
+	if err != nil {
+		return err
+	}
+
+	rv := ds.recvMsg.Op.Request.ResourceOptions.ResourceVersion
+	for {
+		if rv == "" {
+			lr.Options.ResourceVersion = ""
+			list, err := lr.List(ds.client)
+			if err != nil {
+				return err
+			}
+
+			dataBytes, err := list.MarshalJSON()
+			if err != nil {
+				return err
+			}
+
+			rv = list.GetResourceVersion()
+			if err := send(DataWatchEvent{Type: WatchEventType.sync, Object: dataBytes, ResourceVersion: rv}); err != nil {
+				return err
+			}
+		}
+
+		lr.Options.ResourceVersion = rv
+		lr.Options.AllowWatchBookmarks = true
+
+		w, err := lr.Watch(ctx, ds.client)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+				rv = ""
+				continue
+			}
+			return err
+		}
+
+		rv, err = streamWatchEvents(ctx, w, rv, send)
+		w.Stop()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, errWatchExpired) {
+			rv = ""
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// The server closed the watch, resume it from the last seen resourceVersion.
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(watchRetryInterval):
+		}
+	}
+}
+
+func streamWatchEvents(ctx context.Context, w watch.Interface, rv string, send func(DataWatchEvent) error) (string, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return rv, nil
+		case ev, ok := <-w.ResultChan():
+			if !ok {
+				return rv, nil
+			}
+
+			if ev.Type == watch.Error {
+				statusErr := apierrors.FromObject(ev.Object)
+				if apierrors.IsResourceExpired(statusErr) || apierrors.IsGone(statusErr) {
+					return rv, errWatchExpired
+				}
+				return rv, statusErr
+			}
+
+			obj, err := meta.Accessor(ev.Object)
+			if err != nil {
+				return rv, err
+			}
+			rv = obj.GetResourceVersion()
+
+			if ev.Type == watch.Bookmark {
+				if err := send(DataWatchEvent{Type: WatchEventType.bookmark, ResourceVersion: rv}); err != nil {
+					return rv, err
+				}
+				continue
+			}
+
+			dataBytes, err := json.Marshal(ev.Object)
+			if err != nil {
+				return rv, err
+			}
+
+			if err := send(DataWatchEvent{Type: string(ev.Type), Object: dataBytes, ResourceVersion: rv}); err != nil {
+				return rv, err
+			}
+		}
+	}
+}
+
+func (dss DataStreamSession) Watch(recvMsg DataStreamMessage, client *dynamic.DynamicClient) error {
+	var ds DataStream
+	ds.client = client
+	ds.recvMsg = recvMsg
+
+	dsm := DataStreamMessage{
+		Op: DataStreamOp{
+			OpID: recvMsg.Op.OpID,
+			Type: WSOpType.watch,
+		},
+	}
+
+	if recvMsg.Op.OpID == "" {
+		dsm.Error = fmt.Sprintf("%s op requires an opID", WSOpType.watch)
+		return dss.WriteJSON(dsm)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dw := &DataWatch{cancel: cancel}
+	dss.watches.Set(recvMsg.Op.OpID, dw)
+
+	go func() {
+		defer dss.watches.Delete(recvMsg.Op.OpID, dw)
+		defer cancel()
+
+		kubeErr := ds.kubeWatch(ctx, func(ev DataWatchEvent) error {
+			b, err := json.Marshal(ev)
+			if err != nil {
+				return err
+			}
+			dsm.Data = string(b)
+			return dss.WriteJSON(dsm)
+		})
+		if kubeErr != nil && ctx.Err() == nil {
+			dsm.Data = ""
+			dsm.Error = kubeErr.Error()
+			dss.WriteJSON(dsm)
+		}
+	}()
+
+	return nil
+}
+
+func (dss DataStreamSession) Unwatch(recvMsg DataStreamMessage) error {
+	dsm := DataStreamMessage{
+		Op: DataStreamOp{
+			OpID: recvMsg.Op.OpID,
+			Type: WSOpType.unwatch,
+		},
+	}
+
+	if !dss.watches.Stop(recvMsg.Op.OpID) {
+		dsm.Error = fmt.Sprintf("no active watch for opID '%s'", recvMsg.Op.OpID)
+	}
+
+	return dss.WriteJSON(dsm)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"sync"
 	"time"
 
@@ -11,10 +12,13 @@ import (
 	"helm.sh/helm/v3/pkg/chartutil"
 	"helm.sh/helm/v3/pkg/release"
 	authv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -22,10 +26,19 @@ import (
 )
 
 type DataStreamSession struct {
-	id    string
-	bound chan error
-	ws    *websocket.Conn
-	close chan struct{}
+	id      string
+	bound   chan error
+	ws      *websocket.Conn
+	close   chan struct{}
+	lock    *sync.Mutex
+	watches *DataWatchMap
+	owner   helmJobOwner
+}
+
+func (dss DataStreamSession) WriteJSON(v interface{}) error {
+	dss.lock.Lock()
+	defer dss.lock.Unlock()
+	return dss.ws.WriteJSON(v)
 }
 
 type DataStreamMessage struct {
@@ -40,6 +53,7 @@ type DataStreamOp struct {
 	Type    string          `json:"type"`
 	Request FrontendRequest `json:"request"`
 	OpID    string          `json:"opID"`
+	JobID   string          `json:"jobId,omitempty"`
 }
 
 type DataSessionMap struct {
@@ -63,7 +77,8 @@ func (dsm *DataSessionMap) Close(sessionId string, status uint, reason string) {
 	dsm.Lock.Lock()
 	defer dsm.Lock.Unlock()
 	ses := dsm.Sessions[sessionId]
-	ses.ws.WriteJSON(DataStreamMessage{Op: DataStreamOp{Type: "close"}, Data: reason, StatusCode: status})
+	ses.watches.StopAll()
+	ses.WriteJSON(DataStreamMessage{Op: DataStreamOp{Type: "close"}, Data: reason, StatusCode: status})
 	ses.ws.Close()
 	delete(dsm.Sessions, sessionId)
 }
@@ -72,6 +87,20 @@ type DataStream struct {
 	client  *dynamic.DynamicClient
 	recvMsg DataStreamMessage
 	helm    *Helm
+	job     *HelmJob
+}
+
+// newHelm returns a helm client for a single op, bound to the job of the op
+// when it runs in the background.
+func (ds DataStream) newHelm() Helm {
+	var h Helm
+	h.ActionConfig = ds.helm.ActionConfig
+	h.EnvSettings = ds.helm.EnvSettings
+	if ds.job != nil {
+		h.Context = ds.job.ctx
+		h.Log = ds.job.Log
+	}
+	return h
 }
 
 func (ds DataStream) selfSubjectAccessReview(client kubernetes.Interface) ([]byte, error) {
@@ -181,6 +210,17 @@ func (ds DataStream) kubeGet() ([]byte, error) {
 	return dataBytes, nil
 }
 
+type UpdateConflict struct {
+	Live      *unstructured.Unstructured `json:"live"`
+	Local     []FieldChange              `json:"local"`
+	Remote    []FieldChange              `json:"remote"`
+	Conflicts []string                   `json:"conflicts"`
+	Merged    *unstructured.Unstructured `json:"merged,omitempty"`
+	// Error is set when the changes could not be compared, the client can
+	// still reload the live object.
+	Error string `json:"error,omitempty"`
+}
+
 func (ds DataStream) kubeUpdate() ([]byte, error) {
 	var ur UpdateResource
 	ur.GetOptions = metav1.GetOptions{}
@@ -188,6 +228,7 @@ func (ds DataStream) kubeUpdate() ([]byte, error) {
 	ur.Namespace = ds.recvMsg.Op.Request.Namespace
 	ur.Name = ds.recvMsg.Op.Request.Name
 	ur.Data = []byte(ds.recvMsg.Op.Request.Data)
+	ur.ResourceVersion = ds.recvMsg.Op.Request.ResourceOptions.ResourceVersion
 	ur.GVR = schema.GroupVersionResource{
 		Group:    ds.recvMsg.Op.Request.KubeGVRK.Group,
 		Version:  ds.recvMsg.Op.Request.KubeGVRK.Version,
@@ -200,6 +241,19 @@ func (ds DataStream) kubeUpdate() ([]byte, error) {
 	}
 
 	update, err := ur.Update(ds.client)
+	if apierrors.IsConflict(err) {
+		conflict, confErr := ds.kubeUpdateConflict(ur)
+		if confErr != nil {
+			return nil, fmt.Errorf("%w: %s", err, confErr)
+		}
+
+		dataBytes, confErr := json.Marshal(conflict)
+		if confErr != nil {
+			return nil, confErr
+		}
+
+		return dataBytes, err
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -212,6 +266,294 @@ func (ds DataStream) kubeUpdate() ([]byte, error) {
 	return dataBytes, nil
 }
 
+// kubeUpdateConflict compares the user's edit and the live object against the
+// object the edit started from. When no field was changed on both sides the
+// edit is replayed on top of the live object and returned as merged.
+func (ds DataStream) kubeUpdateConflict(ur UpdateResource) (*UpdateConflict, error) {
+	// Without the object the edit started from there is no base to tell the
+	// user's changes from the remote ones, only the live object is returned.
+	var original *unstructured.Unstructured
+	if ds.recvMsg.Op.Request.Original != "" {
+		original = &unstructured.Unstructured{}
+		if _, _, err := unstructured.UnstructuredJSONScheme.Decode([]byte(ds.recvMsg.Op.Request.Original), ur.GVK, original); err != nil {
+			return nil, fmt.Errorf("could not decode original object: %w", err)
+		}
+	}
+
+	edited := &unstructured.Unstructured{}
+	if _, _, err := unstructured.UnstructuredJSONScheme.Decode(ur.Data, ur.GVK, edited); err != nil {
+		return nil, err
+	}
+
+	var gr GetResource
+	gr.Namespace = ur.Namespace
+	gr.Name = ur.Name
+	gr.Options = metav1.GetOptions{}
+	gr.GVR = ur.GVR
+
+	live, err := gr.Get(ds.client)
+	if err != nil {
+		return nil, err
+	}
+
+	conflict := &UpdateConflict{Live: live, Local: []FieldChange{}, Remote: []FieldChange{}, Conflicts: []string{}}
+	if original == nil {
+		conflict.Error = "the original object is required to resolve the conflict, reload the object and apply the changes again"
+		return conflict, nil
+	}
+
+	conflict.Local = diffObjects(original.Object, edited.Object)
+	conflict.Remote = diffObjects(original.Object, live.Object)
+	conflict.Conflicts = conflictingChanges(conflict.Local, conflict.Remote)
+
+	if len(conflict.Conflicts) == 0 {
+		mergedObj, err := applyFieldChanges(live.Object, conflict.Local)
+		if err != nil {
+			conflict.Error = fmt.Sprintf("could not merge the changes: %s", err)
+			return conflict, nil
+		}
+		merged := &unstructured.Unstructured{Object: mergedObj}
+		merged.SetResourceVersion(live.GetResourceVersion())
+		conflict.Merged = merged
+	}
+
+	return conflict, nil
+}
+
+func (ds DataStream) kubePatch() ([]byte, error) {
+	opts := ds.recvMsg.Op.Request.ResourceOptions
+
+	patchType, ok := patchTypes[opts.PatchType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported patch type '%s', expected one of json, merge, strategic or apply", opts.PatchType)
+	}
+
+	var pr PatchResource
+	pr.Namespace = ds.recvMsg.Op.Request.Namespace
+	pr.Name = ds.recvMsg.Op.Request.Name
+	pr.PatchType = patchType
+	pr.Data = []byte(ds.recvMsg.Op.Request.Data)
+	pr.Options = metav1.PatchOptions{FieldManager: opts.FieldManager, FieldValidation: "Strict"}
+	pr.GVR = schema.GroupVersionResource{
+		Group:    ds.recvMsg.Op.Request.KubeGVRK.Group,
+		Version:  ds.recvMsg.Op.Request.KubeGVRK.Version,
+		Resource: ds.recvMsg.Op.Request.KubeGVRK.Resource,
+	}
+	if !ds.recvMsg.Op.Request.KubeGVRK.IsNamespaced {
+		pr.Namespace = ""
+	}
+	if opts.Subresource != "" {
+		pr.Subresources = []string{opts.Subresource}
+	}
+	if opts.DryRun {
+		pr.Options.DryRun = []string{metav1.DryRunAll}
+	}
+	if patchType == types.ApplyPatchType {
+		if pr.Options.FieldManager == "" {
+			pr.Options.FieldManager = defaultFieldManager
+		}
+		pr.Options.Force = &opts.Force
+	}
+
+	patch, err := pr.Patch(ds.client)
+	if err != nil {
+		return nil, err
+	}
+
+	dataBytes, err := patch.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return dataBytes, nil
+}
+
+func setManifestNamespace(obj *unstructured.Unstructured, mapping *meta.RESTMapping, namespace string) {
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		obj.SetNamespace("")
+		return
+	}
+
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(namespace)
+	}
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(metav1.NamespaceDefault)
+	}
+}
+
+type ManifestResult struct {
+	APIVersion string                     `json:"apiVersion"`
+	Kind       string                     `json:"kind"`
+	Namespace  string                     `json:"namespace"`
+	Name       string                     `json:"name"`
+	Object     *unstructured.Unstructured `json:"object,omitempty"`
+	Error      string                     `json:"error,omitempty"`
+}
+
+func (ds DataStream) kubeManifests(mapper meta.ResettableRESTMapper) ([]byte, error) {
+	objs, err := decodeManifests([]byte(ds.recvMsg.Op.Request.Data))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := ds.recvMsg.Op.Request.ResourceOptions
+	fieldManager := opts.FieldManager
+	if fieldManager == "" {
+		fieldManager = defaultFieldManager
+	}
+	var dryRun []string
+	if opts.DryRun {
+		dryRun = []string{metav1.DryRunAll}
+	}
+
+	results := []ManifestResult{}
+	for _, obj := range objs {
+		res := ManifestResult{APIVersion: obj.GetAPIVersion(), Kind: obj.GetKind(), Name: obj.GetName()}
+
+		mapping, err := restMapping(mapper, obj.GroupVersionKind())
+		if err != nil {
+			res.Error = err.Error()
+			results = append(results, res)
+			continue
+		}
+
+		setManifestNamespace(obj, mapping, ds.recvMsg.Op.Request.Namespace)
+		res.Namespace = obj.GetNamespace()
+
+		var out *unstructured.Unstructured
+		if ds.recvMsg.Op.Type == WSOpType.apply {
+			var ar ApplyResource
+			ar.Name = obj.GetName()
+			ar.Namespace = obj.GetNamespace()
+			ar.GVR = mapping.Resource
+			ar.Object = obj
+			ar.Options = metav1.ApplyOptions{FieldManager: fieldManager, Force: opts.Force, DryRun: dryRun}
+
+			out, err = ar.Apply(ds.client)
+		} else {
+			var cr CreateResource
+			cr.Namespace = obj.GetNamespace()
+			cr.GVR = mapping.Resource
+			cr.Object = obj
+			cr.Options = metav1.CreateOptions{FieldManager: fieldManager, FieldValidation: "Strict", DryRun: dryRun}
+
+			out, err = cr.Create(ds.client)
+		}
+		if err != nil {
+			res.Error = err.Error()
+			results = append(results, res)
+			continue
+		}
+
+		unstructured.RemoveNestedField(out.Object, "metadata", "managedFields")
+		res.Name = out.GetName()
+		res.Object = out
+		results = append(results, res)
+	}
+
+	dataBytes, err := json.Marshal(results)
+	if err != nil {
+		return nil, err
+	}
+
+	return dataBytes, nil
+}
+
+// kubeDiff runs the manifests through a server-side dry-run and compares the
+// result with the live objects.
+func (ds DataStream) kubeDiff(mapper meta.ResettableRESTMapper) ([]byte, error) {
+	objs, err := decodeManifests([]byte(ds.recvMsg.Op.Request.Data))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := ds.recvMsg.Op.Request.ResourceOptions
+	fieldManager := opts.FieldManager
+	if fieldManager == "" {
+		fieldManager = defaultFieldManager
+	}
+	dryRun := []string{metav1.DryRunAll}
+
+	diffs := []ResourceDiff{}
+	for _, obj := range objs {
+		rd := ResourceDiff{APIVersion: obj.GetAPIVersion(), Kind: obj.GetKind(), Name: obj.GetName()}
+
+		mapping, err := restMapping(mapper, obj.GroupVersionKind())
+		if err != nil {
+			rd.Error = err.Error()
+			diffs = append(diffs, rd)
+			continue
+		}
+
+		setManifestNamespace(obj, mapping, ds.recvMsg.Op.Request.Namespace)
+		rd.Namespace = obj.GetNamespace()
+
+		var gr GetResource
+		gr.Name = obj.GetName()
+		gr.Namespace = obj.GetNamespace()
+		gr.GVR = mapping.Resource
+
+		live, err := gr.Get(ds.client)
+		if apierrors.IsNotFound(err) {
+			live = nil
+		} else if err != nil {
+			rd.Error = err.Error()
+			diffs = append(diffs, rd)
+			continue
+		}
+
+		var result *unstructured.Unstructured
+		if opts.DiffStrategy == DiffStrategy.update {
+			if live == nil {
+				rd.Error = fmt.Sprintf("%s '%s' does not exist and can not be updated", obj.GetKind(), obj.GetName())
+				diffs = append(diffs, rd)
+				continue
+			}
+
+			data, err := obj.MarshalJSON()
+			if err != nil {
+				return nil, err
+			}
+
+			gvk := obj.GroupVersionKind()
+			var ur UpdateResource
+			ur.Name = obj.GetName()
+			ur.Namespace = obj.GetNamespace()
+			ur.Data = data
+			ur.ResourceVersion = opts.ResourceVersion
+			ur.GVR = mapping.Resource
+			ur.GVK = &gvk
+			ur.UpdateOptions = metav1.UpdateOptions{FieldValidation: "Strict", DryRun: dryRun}
+
+			result, err = ur.Update(ds.client)
+		} else {
+			var ar ApplyResource
+			ar.Name = obj.GetName()
+			ar.Namespace = obj.GetNamespace()
+			ar.GVR = mapping.Resource
+			ar.Object = obj
+			ar.Options = metav1.ApplyOptions{FieldManager: fieldManager, Force: opts.Force, DryRun: dryRun}
+
+			result, err = ar.Apply(ds.client)
+		}
+		if err != nil {
+			rd.Error = err.Error()
+			diffs = append(diffs, rd)
+			continue
+		}
+
+		diffs = append(diffs, diffResource(live, result))
+	}
+
+	dataBytes, err := json.Marshal(diffs)
+	if err != nil {
+		return nil, err
+	}
+
+	return dataBytes, nil
+}
+
 func (ds DataStream) kubeDelete() ([]byte, error) {
 	var dr DeleteResource
 	dr.Options = metav1.DeleteOptions{}
@@ -312,7 +654,7 @@ func (ds DataStream) helmGet(config *rest.Config) ([]byte, error) {
 	return dataBytes, nil
 }
 
-func (ds DataStream) helmPull(config *rest.Config) (string, error) {
+func (ds DataStream) helmPull(config *rest.Config) ([]byte, error) {
 	var h Helm
 	h.ActionConfig = ds.helm.ActionConfig
 	h.EnvSettings = ds.helm.EnvSettings
@@ -320,20 +662,49 @@ func (ds DataStream) helmPull(config *rest.Config) (string, error) {
 	h.RepoURL = ds.recvMsg.Op.Request.HelmOptions.RepoURL
 	h.ChartVersion = ds.recvMsg.Op.Request.HelmOptions.ChartVersion
 
-	rl, err := h.PullChart(ds.recvMsg.Op.Request.HelmOptions, config)
+	ci, err := h.PullChart(ds.recvMsg.Op.Request.HelmOptions, config)
+	if err != nil {
+		return nil, err
+	}
+
+	dataBytes, err := json.Marshal(ci)
 	if err != nil {
-		fmt.Println("err PullChart:", err)
-		return "", err
+		return nil, err
+	}
+
+	return dataBytes, nil
+}
+
+func (ds DataStream) helmDependencyUpdate() ([]byte, error) {
+	var h Helm
+	h.ActionConfig = ds.helm.ActionConfig
+	h.EnvSettings = ds.helm.EnvSettings
+
+	cd, err := h.UpdateChartDependencies(ds.recvMsg.Op.Request.HelmOptions)
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Println("chart string:", rl)
+	return json.Marshal(cd)
+}
+
+func (ds DataStream) helmLint(config *rest.Config) ([]byte, error) {
+	var h Helm
+	h.ActionConfig = ds.helm.ActionConfig
+	h.EnvSettings = ds.helm.EnvSettings
+	h.ReleaseNamespace = ds.recvMsg.Op.Request.Namespace
+
+	var vals chartutil.Values
+	if err := yaml.Unmarshal([]byte(ds.recvMsg.Op.Request.Data), &vals); err != nil {
+		return nil, err
+	}
 
-	// dataBytes, err := json.Marshal(rl)
-	// if err != nil {
-	// 	return "", err
-	// }
+	lr, err := h.LintChart(config, ds.recvMsg.Op.Request.HelmOptions, vals)
+	if err != nil {
+		return nil, err
+	}
 
-	return rl, nil
+	return json.Marshal(lr)
 }
 
 func (ds DataStream) helmChartTags() (HelmChartTags, error) {
@@ -349,15 +720,16 @@ func (ds DataStream) helmChartTags() (HelmChartTags, error) {
 }
 
 func (ds DataStream) helmInstall(config *rest.Config, opts HelmOptions) ([]byte, error) {
-	var h Helm
-	h.ActionConfig = ds.helm.ActionConfig
-	h.EnvSettings = ds.helm.EnvSettings
+	h := ds.newHelm()
 	h.RepoURL = ds.recvMsg.Op.Request.HelmOptions.RepoURL
 	h.ChartName = ds.recvMsg.Op.Request.HelmOptions.ChartName
 	h.ChartVersion = ds.recvMsg.Op.Request.HelmOptions.ChartVersion
 	h.ReleaseName = ds.recvMsg.Op.Request.Name
 	h.ReleaseNamespace = ds.recvMsg.Op.Request.Namespace
 	h.DryRun = ds.recvMsg.Op.Request.HelmOptions.DryRun
+	h.Wait = opts.Wait
+	h.Atomic = opts.Atomic
+	h.Timeout = helmTimeout(opts)
 	h.AllNamespaces = false
 	h.AllValues = true
 
@@ -366,6 +738,10 @@ func (ds DataStream) helmInstall(config *rest.Config, opts HelmOptions) ([]byte,
 		return nil, err
 	}
 
+	if ds.job != nil {
+		ds.job.Emit(HelmJobPhase.rendering, "locating and rendering the chart")
+	}
+
 	ir, err := h.InstallRelease(config, opts, vals)
 	if err != nil {
 		return nil, err
@@ -388,15 +764,16 @@ func (ds DataStream) helmInstall(config *rest.Config, opts HelmOptions) ([]byte,
 }
 
 func (ds DataStream) helmUpgrade(config *rest.Config, opts HelmOptions) ([]byte, error) {
-	var h Helm
-	h.ActionConfig = ds.helm.ActionConfig
-	h.EnvSettings = ds.helm.EnvSettings
+	h := ds.newHelm()
 	h.RepoURL = ds.recvMsg.Op.Request.HelmOptions.RepoURL
 	h.ChartName = ds.recvMsg.Op.Request.HelmOptions.ChartName
 	h.ChartVersion = ds.recvMsg.Op.Request.HelmOptions.ChartVersion
 	h.ReleaseName = ds.recvMsg.Op.Request.Name
 	h.ReleaseNamespace = ds.recvMsg.Op.Request.Namespace
 	h.DryRun = ds.recvMsg.Op.Request.HelmOptions.DryRun
+	h.Wait = opts.Wait
+	h.Atomic = opts.Atomic
+	h.Timeout = helmTimeout(opts)
 	h.AllNamespaces = false
 	h.AllValues = true
 
@@ -405,233 +782,994 @@ func (ds DataStream) helmUpgrade(config *rest.Config, opts HelmOptions) ([]byte,
 		return nil, err
 	}
 
+	if ds.job != nil {
+		ds.job.Emit(HelmJobPhase.rendering, "locating and rendering the chart")
+	}
+
 	ur, err := h.UpgradeRelease(config, opts, vals)
 	if err != nil {
 		return nil, err
 	}
 
-	dataBytes, err := json.Marshal(release.Release{
-		Name:      ur.Name,
-		Namespace: ur.Namespace,
-		Chart: &chart.Chart{
-			Metadata: ur.Chart.Metadata,
+	dataBytes, err := json.Marshal(release.Release{
+		Name:      ur.Name,
+		Namespace: ur.Namespace,
+		Chart: &chart.Chart{
+			Metadata: ur.Chart.Metadata,
+		},
+		Config: ur.Config,
+		Info:   ur.Info,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dataBytes, nil
+}
+
+// helmDiff renders the upgrade as a dry-run and compares its manifest with the
+// manifest of the deployed release.
+func (ds DataStream) helmDiff(config *rest.Config, opts HelmOptions) ([]byte, error) {
+	var h Helm
+	h.ActionConfig = ds.helm.ActionConfig
+	h.EnvSettings = ds.helm.EnvSettings
+	h.RepoURL = ds.recvMsg.Op.Request.HelmOptions.RepoURL
+	h.ChartName = ds.recvMsg.Op.Request.HelmOptions.ChartName
+	h.ChartVersion = ds.recvMsg.Op.Request.HelmOptions.ChartVersion
+	h.ReleaseName = ds.recvMsg.Op.Request.Name
+	h.ReleaseNamespace = ds.recvMsg.Op.Request.Namespace
+	h.DryRun = true
+	h.AllNamespaces = false
+
+	var vals chartutil.Values
+	if err := yaml.Unmarshal([]byte(ds.recvMsg.Op.Request.Data), &vals); err != nil {
+		return nil, err
+	}
+
+	current, err := h.GetRelease(config)
+	if err != nil {
+		return nil, err
+	}
+
+	upgraded, err := h.UpgradeRelease(config, opts, vals)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs, err := diffManifests(current.Manifest, upgraded.Manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	dataBytes, err := json.Marshal(diffs)
+	if err != nil {
+		return nil, err
+	}
+
+	return dataBytes, nil
+}
+
+func (ds DataStream) helmTemplate(config *rest.Config, opts HelmOptions) ([]byte, error) {
+	var h Helm
+	h.ActionConfig = ds.helm.ActionConfig
+	h.EnvSettings = ds.helm.EnvSettings
+	h.ReleaseName = ds.recvMsg.Op.Request.Name
+	h.ReleaseNamespace = ds.recvMsg.Op.Request.Namespace
+
+	var vals chartutil.Values
+	if err := yaml.Unmarshal([]byte(ds.recvMsg.Op.Request.Data), &vals); err != nil {
+		return nil, err
+	}
+
+	rl, err := h.TemplateChart(config, opts, vals)
+	if err != nil {
+		return nil, err
+	}
+
+	dataBytes, err := json.Marshal(newHelmManifest(rl))
+	if err != nil {
+		return nil, err
+	}
+
+	return dataBytes, nil
+}
+
+func (ds DataStream) helmGetManifest(config *rest.Config, opts HelmOptions) ([]byte, error) {
+	var h Helm
+	h.ActionConfig = ds.helm.ActionConfig
+	h.EnvSettings = ds.helm.EnvSettings
+	h.ReleaseName = ds.recvMsg.Op.Request.Name
+	h.ReleaseNamespace = ds.recvMsg.Op.Request.Namespace
+	h.ReleaseVersion = opts.Revision
+
+	rl, err := h.GetRelease(config)
+	if err != nil {
+		return nil, err
+	}
+
+	dataBytes, err := json.Marshal(newHelmManifest(rl))
+	if err != nil {
+		return nil, err
+	}
+
+	return dataBytes, nil
+}
+
+func (ds DataStream) helmValues(config *rest.Config, opts HelmOptions) ([]byte, error) {
+	var h Helm
+	h.ActionConfig = ds.helm.ActionConfig
+	h.EnvSettings = ds.helm.EnvSettings
+	h.ReleaseName = ds.recvMsg.Op.Request.Name
+	h.ReleaseNamespace = ds.recvMsg.Op.Request.Namespace
+
+	var vals chartutil.Values
+	if err := yaml.Unmarshal([]byte(ds.recvMsg.Op.Request.Data), &vals); err != nil {
+		return nil, err
+	}
+
+	var (
+		data interface{}
+		err  error
+	)
+	if ds.recvMsg.Op.Type == WSOpType.helmValuesDiff {
+		data, err = h.DiffValues(config, opts, vals)
+	} else {
+		data, err = h.ValidateValues(config, opts, vals)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return dataBytes, nil
+}
+
+type HelmRevision struct {
+	Revision      int       `json:"revision"`
+	Status        string    `json:"status"`
+	Chart         string    `json:"chart"`
+	ChartVersion  string    `json:"chartVersion"`
+	AppVersion    string    `json:"appVersion"`
+	Description   string    `json:"description"`
+	FirstDeployed time.Time `json:"firstDeployed"`
+	LastDeployed  time.Time `json:"lastDeployed"`
+	Deleted       time.Time `json:"deleted"`
+}
+
+func (ds DataStream) helmHistory(config *rest.Config) ([]byte, error) {
+	var h Helm
+	h.ActionConfig = ds.helm.ActionConfig
+	h.EnvSettings = ds.helm.EnvSettings
+	h.ReleaseName = ds.recvMsg.Op.Request.Name
+	h.ReleaseNamespace = ds.recvMsg.Op.Request.Namespace
+
+	hr, err := h.ReleaseHistory(config)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := []HelmRevision{}
+	for _, v := range hr {
+		rev := HelmRevision{Revision: v.Version}
+		if v.Info != nil {
+			rev.Status = v.Info.Status.String()
+			rev.Description = v.Info.Description
+			rev.FirstDeployed = v.Info.FirstDeployed.Time
+			rev.LastDeployed = v.Info.LastDeployed.Time
+			rev.Deleted = v.Info.Deleted.Time
+		}
+		if v.Chart != nil && v.Chart.Metadata != nil {
+			rev.Chart = v.Chart.Metadata.Name
+			rev.ChartVersion = v.Chart.Metadata.Version
+			rev.AppVersion = v.Chart.Metadata.AppVersion
+		}
+		revisions = append(revisions, rev)
+	}
+
+	dataBytes, err := json.Marshal(revisions)
+	if err != nil {
+		return nil, err
+	}
+
+	return dataBytes, nil
+}
+
+func (ds DataStream) helmRollback(config *rest.Config, opts HelmOptions) ([]byte, error) {
+	h := ds.newHelm()
+	h.ReleaseName = ds.recvMsg.Op.Request.Name
+	h.ReleaseNamespace = ds.recvMsg.Op.Request.Namespace
+	h.ReleaseVersion = opts.Revision
+	h.DryRun = opts.DryRun
+	h.Wait = opts.Wait
+	h.Timeout = helmTimeout(opts)
+
+	if h.DryRun {
+		return ds.helmRollbackPreview(config, &h)
+	}
+
+	if err := h.RollbackRelease(config); err != nil {
+		return nil, err
+	}
+
+	h.ReleaseVersion = 0
+	rl, err := h.GetRelease(config)
+	if err != nil {
+		return nil, err
+	}
+
+	dataBytes, err := json.Marshal(release.Release{
+		Name:      rl.Name,
+		Namespace: rl.Namespace,
+		Version:   rl.Version,
+		Chart: &chart.Chart{
+			Metadata: rl.Chart.Metadata,
+		},
+		Config: rl.Config,
+		Info:   rl.Info,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dataBytes, nil
+}
+
+type HelmRollbackPreview struct {
+	Name           string                 `json:"name"`
+	Namespace      string                 `json:"namespace"`
+	Revision       int                    `json:"revision"`
+	TargetRevision int                    `json:"targetRevision"`
+	Manifest       string                 `json:"manifest"`
+	Config         map[string]interface{} `json:"config"`
+	Diffs          []ResourceDiff         `json:"diffs"`
+}
+
+// helmRollbackPreview returns the manifest and values of the revision the
+// release would be rolled back to and the diff against the current revision.
+// Like helm, revision 0 means the previous revision.
+func (ds DataStream) helmRollbackPreview(config *rest.Config, h *Helm) ([]byte, error) {
+	target := h.ReleaseVersion
+
+	h.ReleaseVersion = 0
+	current, err := h.GetRelease(config)
+	if err != nil {
+		return nil, err
+	}
+	if target == 0 {
+		target = current.Version - 1
+	}
+	if target < 1 {
+		return nil, fmt.Errorf("release '%s' has no previous revision to roll back to", current.Name)
+	}
+
+	h.ReleaseVersion = target
+	rl, err := h.GetRelease(config)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs, err := diffManifests(current.Manifest, rl.Manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	dataBytes, err := json.Marshal(HelmRollbackPreview{
+		Name:           rl.Name,
+		Namespace:      rl.Namespace,
+		Revision:       current.Version,
+		TargetRevision: rl.Version,
+		Manifest:       rl.Manifest,
+		Config:         rl.Config,
+		Diffs:          diffs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dataBytes, nil
+}
+
+// helmTest runs the test hooks of the release, a failed test still returns
+// the test results next to the error.
+func (ds DataStream) helmTest(config *rest.Config, opts HelmOptions) ([]byte, error) {
+	h := ds.newHelm()
+	h.ReleaseName = ds.recvMsg.Op.Request.Name
+	h.ReleaseNamespace = ds.recvMsg.Op.Request.Namespace
+	h.Timeout = helmTimeout(opts)
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := h.GetRelease(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// The log sessions of the test pods are announced as job events while the
+	// tests run.
+	tpl, err := watchTestPods(h.context(), clientset, current, func(tl HelmTestLog) {
+		if ds.job == nil {
+			return
+		}
+		if b, err := json.Marshal(tl); err == nil {
+			ds.job.Emit(HelmJobPhase.testLog, string(b))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rl, testErr := h.TestRelease(config)
+	tpl.Stop()
+	if rl == nil {
+		return nil, testErr
+	}
+
+	dataBytes, err := json.Marshal(HelmTestResult{
+		Name:      rl.Name,
+		Namespace: rl.Namespace,
+		Passed:    testErr == nil,
+		Tests:     testHooks(clientset, rl, tpl),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dataBytes, testErr
+}
+
+func (ds DataStream) helmStatus(config *rest.Config, opts HelmOptions) ([]byte, error) {
+	var h Helm
+	h.ActionConfig = ds.helm.ActionConfig
+	h.EnvSettings = ds.helm.EnvSettings
+	h.ReleaseName = ds.recvMsg.Op.Request.Name
+	h.ReleaseNamespace = ds.recvMsg.Op.Request.Namespace
+	h.ReleaseVersion = opts.Revision
+
+	hs, err := h.ReleaseStatus(config)
+	if err != nil {
+		return nil, err
+	}
+
+	dataBytes, err := json.Marshal(hs)
+	if err != nil {
+		return nil, err
+	}
+
+	return dataBytes, nil
+}
+
+func (ds DataStream) helmUninstall(config *rest.Config) (*release.UninstallReleaseResponse, error) {
+	h := ds.newHelm()
+	h.ReleaseName = ds.recvMsg.Op.Request.Name
+	h.ReleaseNamespace = ds.recvMsg.Op.Request.Namespace
+	h.DryRun = ds.recvMsg.Op.Request.HelmOptions.DryRun
+
+	urr, err := h.UninstallRelease(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return urr, nil
+}
+
+func (ds DataStream) helmRepo() ([]byte, error) {
+	var h Helm
+	h.ActionConfig = ds.helm.ActionConfig
+	h.EnvSettings = ds.helm.EnvSettings
+
+	repoOpts := ds.recvMsg.Op.Request.HelmOptions.Repository
+
+	var (
+		data interface{}
+		err  error
+	)
+	switch ds.recvMsg.Op.Type {
+	case WSOpType.helmRepoAdd:
+		data, err = h.AddRepository(repoOpts)
+	case WSOpType.helmRepoList:
+		data, err = h.ListRepositories()
+	case WSOpType.helmRepoUpdate:
+		data, err = h.UpdateRepositories(repoOpts.Name)
+	case WSOpType.helmRepoRemove:
+		err = h.RemoveRepository(repoOpts.Name)
+		data = repoOpts.Name
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return dataBytes, nil
+}
+
+func (ds DataStream) helmSearch() ([]byte, error) {
+	var h Helm
+	h.ActionConfig = ds.helm.ActionConfig
+	h.EnvSettings = ds.helm.EnvSettings
+
+	cs, err := h.SearchCharts(ds.recvMsg.Op.Request.HelmOptions.Search)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(cs)
+}
+
+func (ds DataStream) helmRegistry() (string, error) {
+	var h Helm
+	h.ActionConfig = ds.helm.ActionConfig
+	h.EnvSettings = ds.helm.EnvSettings
+
+	if ds.recvMsg.Op.Type == WSOpType.helmRegistryLogout {
+		return h.RegistryLogout(ds.recvMsg.Op.Request.HelmOptions.Registry)
+	}
+	return h.RegistryLogin(ds.recvMsg.Op.Request.HelmOptions.Registry)
+}
+
+func (dss DataStreamSession) AccessReview(recvMsg DataStreamMessage, client *kubernetes.Clientset) error {
+	var ds DataStream
+	ds.recvMsg = recvMsg
+
+	dsm := DataStreamMessage{
+		Op: DataStreamOp{
+			OpID: recvMsg.Op.OpID,
+			Type: WSOpType.accessReview,
+		},
+	}
+
+	data, err := ds.selfSubjectAccessReview(client)
+	if err != nil {
+		dsm.Error = err.Error()
+	}
+
+	dsm.Data = string(data)
+
+	if err := dss.WriteJSON(dsm); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (dss DataStreamSession) RulesReview(recvMsg DataStreamMessage, client *kubernetes.Clientset) error {
+	var ds DataStream
+	ds.recvMsg = recvMsg
+
+	dsm := DataStreamMessage{
+		Op: DataStreamOp{
+			OpID: recvMsg.Op.OpID,
+			Type: WSOpType.rulesReview,
+		},
+	}
+
+	data, err := ds.selfSubjectRulesReview(client)
+	if err != nil {
+		dsm.Error = err.Error()
+	}
+
+	dsm.Data = string(data)
+
+	if err := dss.WriteJSON(dsm); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (dss DataStreamSession) List(recvMsg DataStreamMessage, client *dynamic.DynamicClient) error {
+	var ds DataStream
+	ds.client = client
+	ds.recvMsg = recvMsg
+
+	dsm := DataStreamMessage{
+		Op: DataStreamOp{
+			OpID: recvMsg.Op.OpID,
+			Type: WSOpType.list,
+		},
+	}
+
+	data, _, kubeErr := ds.kubeList()
+	if kubeErr != nil {
+		dsm.Error = kubeErr.Error()
+	}
+
+	dsm.Data = string(data)
+
+	if err := dss.WriteJSON(dsm); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (dss DataStreamSession) ListAll(recvMsg DataStreamMessage, client *dynamic.DynamicClient) error {
+	var ds DataStream
+	ds.client = client
+	ds.recvMsg = recvMsg
+
+	dsm := DataStreamMessage{
+		Op: DataStreamOp{
+			OpID: recvMsg.Op.OpID,
+			Type: WSOpType.listAll,
+		},
+	}
+
+	var kubeData map[string]unstructured.UnstructuredList = make(map[string]unstructured.UnstructuredList)
+	for _, gvr := range ds.recvMsg.Op.Request.KubeGVRKList {
+		ds.recvMsg.Op.Request.KubeGVRK = gvr
+		_, listData, kubeErr := ds.kubeList()
+		if kubeErr != nil && kubeErr.Error() != errCouldNotFindResource.Error() {
+			dsm.Error = kubeErr.Error()
+		}
+
+		kubeData[gvr.Resource] = listData
+	}
+
+	b, err := json.Marshal(kubeData)
+	if err != nil {
+		return err
+	}
+
+	dsm.Data = string(b)
+
+	if err := dss.WriteJSON(dsm); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (dss DataStreamSession) Get(recvMsg DataStreamMessage, client *dynamic.DynamicClient) error {
+	var ds DataStream
+	ds.client = client
+	ds.recvMsg = recvMsg
+
+	dsm := DataStreamMessage{
+		Op: DataStreamOp{
+			OpID: recvMsg.Op.OpID,
+			Type: WSOpType.get,
+		},
+	}
+
+	data, kubeErr := ds.kubeGet()
+	if kubeErr != nil {
+		dsm.Error = kubeErr.Error()
+	}
+
+	dsm.Data = string(data)
+
+	if err := dss.WriteJSON(dsm); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (dss DataStreamSession) Update(recvMsg DataStreamMessage, client *dynamic.DynamicClient) error {
+	var ds DataStream
+	ds.client = client
+	ds.recvMsg = recvMsg
+
+	dsm := DataStreamMessage{
+		Op: DataStreamOp{
+			OpID: recvMsg.Op.OpID,
+			Type: WSOpType.update,
+		},
+	}
+
+	data, kubeErr := ds.kubeUpdate()
+	if kubeErr != nil {
+		dsm.Error = kubeErr.Error()
+	}
+	if apierrors.IsConflict(kubeErr) {
+		dsm.StatusCode = http.StatusConflict
+	}
+
+	dsm.Data = string(data)
+
+	if err := dss.WriteJSON(dsm); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (dss DataStreamSession) Patch(recvMsg DataStreamMessage, client *dynamic.DynamicClient) error {
+	var ds DataStream
+	ds.client = client
+	ds.recvMsg = recvMsg
+
+	dsm := DataStreamMessage{
+		Op: DataStreamOp{
+			OpID: recvMsg.Op.OpID,
+			Type: WSOpType.patch,
+		},
+	}
+
+	data, kubeErr := ds.kubePatch()
+	if kubeErr != nil {
+		dsm.Error = kubeErr.Error()
+	}
+
+	dsm.Data = string(data)
+
+	if err := dss.WriteJSON(dsm); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (dss DataStreamSession) Manifests(recvMsg DataStreamMessage, client *dynamic.DynamicClient, mapper meta.ResettableRESTMapper) error {
+	var ds DataStream
+	ds.client = client
+	ds.recvMsg = recvMsg
+
+	dsm := DataStreamMessage{
+		Op: DataStreamOp{
+			OpID: recvMsg.Op.OpID,
+			Type: recvMsg.Op.Type,
+		},
+	}
+
+	data, kubeErr := ds.kubeManifests(mapper)
+	if kubeErr != nil {
+		dsm.Error = kubeErr.Error()
+	}
+
+	dsm.Data = string(data)
+
+	if err := dss.WriteJSON(dsm); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (dss DataStreamSession) Diff(recvMsg DataStreamMessage, client *dynamic.DynamicClient, mapper meta.ResettableRESTMapper) error {
+	var ds DataStream
+	ds.client = client
+	ds.recvMsg = recvMsg
+
+	dsm := DataStreamMessage{
+		Op: DataStreamOp{
+			OpID: recvMsg.Op.OpID,
+			Type: WSOpType.diff,
+		},
+	}
+
+	data, kubeErr := ds.kubeDiff(mapper)
+	if kubeErr != nil {
+		dsm.Error = kubeErr.Error()
+	}
+
+	dsm.Data = string(data)
+
+	if err := dss.WriteJSON(dsm); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (dss DataStreamSession) Delete(recvMsg DataStreamMessage, client *dynamic.DynamicClient) error {
+	var ds DataStream
+	ds.client = client
+	ds.recvMsg = recvMsg
+
+	dsm := DataStreamMessage{
+		Op: DataStreamOp{
+			OpID: recvMsg.Op.OpID,
+			Type: WSOpType.delete,
+		},
+	}
+
+	_, kubeErr := ds.kubeDelete()
+	if kubeErr != nil {
+		dsm.Error = kubeErr.Error()
+	}
+
+	if err := dss.WriteJSON(dsm); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (dss DataStreamSession) ShowValuesHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
+	var ds DataStream
+	ds.recvMsg = recvMsg
+	ds.helm = h
+
+	dsm := DataStreamMessage{
+		Op: DataStreamOp{
+			OpID: recvMsg.Op.OpID,
+			Type: WSOpType.helmShowValues,
 		},
-		Config: ur.Config,
-		Info:   ur.Info,
-	})
-	if err != nil {
-		return nil, err
 	}
 
-	return dataBytes, nil
-}
+	data, helmErr := ds.helmShowValues(config)
+	if helmErr != nil {
+		dsm.Error = helmErr.Error()
+	}
 
-func (ds DataStream) helmUninstall(config *rest.Config) (*release.UninstallReleaseResponse, error) {
-	var h Helm
-	h.ActionConfig = ds.helm.ActionConfig
-	h.EnvSettings = ds.helm.EnvSettings
-	h.ReleaseName = ds.recvMsg.Op.Request.Name
-	h.ReleaseNamespace = ds.recvMsg.Op.Request.Namespace
-	h.DryRun = ds.recvMsg.Op.Request.HelmOptions.DryRun
+	dsm.Data = data
 
-	urr, err := h.UninstallRelease(config)
-	if err != nil {
-		return nil, err
+	if err := dss.WriteJSON(dsm); err != nil {
+		return err
 	}
 
-	return urr, nil
+	return nil
 }
 
-func (dss DataStreamSession) AccessReview(recvMsg DataStreamMessage, client *kubernetes.Clientset) error {
+func (dss DataStreamSession) ListHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
 	var ds DataStream
 	ds.recvMsg = recvMsg
+	ds.helm = h
 
 	dsm := DataStreamMessage{
 		Op: DataStreamOp{
 			OpID: recvMsg.Op.OpID,
-			Type: WSOpType.accessReview,
+			Type: WSOpType.helmList,
 		},
 	}
 
-	data, err := ds.selfSubjectAccessReview(client)
-	if err != nil {
-		dsm.Error = err.Error()
+	data, helmErr := ds.helmList(config)
+	if helmErr != nil {
+		dsm.Error = helmErr.Error()
 	}
 
 	dsm.Data = string(data)
 
-	if err := dss.ws.WriteJSON(dsm); err != nil {
+	if err := dss.WriteJSON(dsm); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (dss DataStreamSession) RulesReview(recvMsg DataStreamMessage, client *kubernetes.Clientset) error {
+func (dss DataStreamSession) GetHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
 	var ds DataStream
 	ds.recvMsg = recvMsg
+	ds.helm = h
 
 	dsm := DataStreamMessage{
 		Op: DataStreamOp{
 			OpID: recvMsg.Op.OpID,
-			Type: WSOpType.rulesReview,
+			Type: WSOpType.helmGet,
 		},
 	}
 
-	data, err := ds.selfSubjectRulesReview(client)
-	if err != nil {
-		dsm.Error = err.Error()
+	data, helmErr := ds.helmGet(config)
+	if helmErr != nil {
+		dsm.Error = helmErr.Error()
 	}
 
 	dsm.Data = string(data)
 
-	if err := dss.ws.WriteJSON(dsm); err != nil {
+	if err := dss.WriteJSON(dsm); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (dss DataStreamSession) List(recvMsg DataStreamMessage, client *dynamic.DynamicClient) error {
+func (dss DataStreamSession) PullHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
 	var ds DataStream
-	ds.client = client
 	ds.recvMsg = recvMsg
+	ds.helm = h
 
 	dsm := DataStreamMessage{
 		Op: DataStreamOp{
 			OpID: recvMsg.Op.OpID,
-			Type: WSOpType.list,
+			Type: WSOpType.helmPull,
 		},
 	}
 
-	data, _, kubeErr := ds.kubeList()
-	if kubeErr != nil {
-		dsm.Error = kubeErr.Error()
+	data, helmErr := ds.helmPull(config)
+	if helmErr != nil {
+		dsm.Error = helmErr.Error()
 	}
 
 	dsm.Data = string(data)
 
-	if err := dss.ws.WriteJSON(dsm); err != nil {
+	if err := dss.WriteJSON(dsm); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (dss DataStreamSession) ListAll(recvMsg DataStreamMessage, client *dynamic.DynamicClient) error {
+func (dss DataStreamSession) GetHelmTags(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
 	var ds DataStream
-	ds.client = client
 	ds.recvMsg = recvMsg
+	ds.helm = h
 
 	dsm := DataStreamMessage{
 		Op: DataStreamOp{
 			OpID: recvMsg.Op.OpID,
-			Type: WSOpType.listAll,
+			Type: WSOpType.helmGetTags,
 		},
 	}
 
-	var kubeData map[string]unstructured.UnstructuredList = make(map[string]unstructured.UnstructuredList)
-	for _, gvr := range ds.recvMsg.Op.Request.KubeGVRKList {
-		ds.recvMsg.Op.Request.KubeGVRK = gvr
-		_, listData, kubeErr := ds.kubeList()
-		if kubeErr != nil && kubeErr.Error() != errCouldNotFindResource.Error() {
-			dsm.Error = kubeErr.Error()
-		}
-
-		kubeData[gvr.Resource] = listData
+	data, helmErr := ds.helmChartTags()
+	if helmErr != nil {
+		dsm.Error = helmErr.Error()
 	}
 
-	b, err := json.Marshal(kubeData)
+	b, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
 
 	dsm.Data = string(b)
 
-	if err := dss.ws.WriteJSON(dsm); err != nil {
+	if err := dss.WriteJSON(dsm); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (dss DataStreamSession) Get(recvMsg DataStreamMessage, client *dynamic.DynamicClient) error {
+// runHelmJob runs a long-running helm op as a job in the background, so the
+// read loop of the session keeps serving other ops meanwhile. Only the ops
+// that take a context can be cancelled.
+func (dss DataStreamSession) runHelmJob(recvMsg DataStreamMessage, h *Helm, cancellable bool, run func(ds DataStream) ([]byte, error)) error {
+	job, err := helmJobs.Start(dss, recvMsg, cancellable)
+	if err != nil {
+		dsm := DataStreamMessage{
+			Op: DataStreamOp{
+				OpID: recvMsg.Op.OpID,
+				Type: recvMsg.Op.Type,
+			},
+			Error: err.Error(),
+		}
+		return dss.WriteJSON(dsm)
+	}
+
+	go func() {
+		var ds DataStream
+		ds.recvMsg = recvMsg
+		ds.helm = h
+		ds.job = job
+
+		data, err := run(ds)
+		job.Finish(data, err)
+	}()
+
+	return nil
+}
+
+func (ds DataStream) helmJob(owner helmJobOwner) ([]byte, error) {
+	jobID := ds.recvMsg.Op.JobID
+	if jobID == "" && ds.recvMsg.Op.Type == WSOpType.helmJobStatus {
+		return json.Marshal(helmJobs.List(owner))
+	}
+
+	job, err := helmJobs.Get(jobID, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	if ds.recvMsg.Op.Type == WSOpType.helmJobCancel {
+		if err := job.Cancel(); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(job.State())
+}
+
+// JobHelm reports the state of a helm job, or all jobs of the session when no
+// job ID is given, and cancels jobs. Querying a job attaches this session to
+// it, so a reconnected client keeps receiving its events.
+func (dss DataStreamSession) JobHelm(recvMsg DataStreamMessage, h *Helm) error {
 	var ds DataStream
-	ds.client = client
 	ds.recvMsg = recvMsg
+	ds.helm = h
 
 	dsm := DataStreamMessage{
 		Op: DataStreamOp{
-			OpID: recvMsg.Op.OpID,
-			Type: WSOpType.get,
+			OpID:  recvMsg.Op.OpID,
+			Type:  recvMsg.Op.Type,
+			JobID: recvMsg.Op.JobID,
 		},
 	}
 
-	data, kubeErr := ds.kubeGet()
-	if kubeErr != nil {
-		dsm.Error = kubeErr.Error()
+	if job, err := helmJobs.Get(recvMsg.Op.JobID, dss.owner); err == nil {
+		job.Attach(dss)
+	}
+
+	data, helmErr := ds.helmJob(dss.owner)
+	if helmErr != nil {
+		dsm.Error = helmErr.Error()
 	}
 
 	dsm.Data = string(data)
 
-	if err := dss.ws.WriteJSON(dsm); err != nil {
+	if err := dss.WriteJSON(dsm); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (dss DataStreamSession) Update(recvMsg DataStreamMessage, client *dynamic.DynamicClient) error {
+func (dss DataStreamSession) InstallHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
+	return dss.runHelmJob(recvMsg, h, true, func(ds DataStream) ([]byte, error) {
+		return ds.helmInstall(config, ds.recvMsg.Op.Request.HelmOptions)
+	})
+}
+
+func (dss DataStreamSession) UpgradeHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
+	return dss.runHelmJob(recvMsg, h, true, func(ds DataStream) ([]byte, error) {
+		return ds.helmUpgrade(config, ds.recvMsg.Op.Request.HelmOptions)
+	})
+}
+
+func (dss DataStreamSession) DiffHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
 	var ds DataStream
-	ds.client = client
 	ds.recvMsg = recvMsg
+	ds.helm = h
 
 	dsm := DataStreamMessage{
 		Op: DataStreamOp{
 			OpID: recvMsg.Op.OpID,
-			Type: WSOpType.update,
+			Type: WSOpType.helmDiff,
 		},
 	}
 
-	data, kubeErr := ds.kubeUpdate()
-	if kubeErr != nil {
-		dsm.Error = kubeErr.Error()
+	data, helmErr := ds.helmDiff(config, ds.recvMsg.Op.Request.HelmOptions)
+	if helmErr != nil {
+		dsm.Error = helmErr.Error()
 	}
 
 	dsm.Data = string(data)
 
-	if err := dss.ws.WriteJSON(dsm); err != nil {
+	if err := dss.WriteJSON(dsm); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (dss DataStreamSession) Delete(recvMsg DataStreamMessage, client *dynamic.DynamicClient) error {
+func (dss DataStreamSession) TemplateHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
 	var ds DataStream
-	ds.client = client
 	ds.recvMsg = recvMsg
+	ds.helm = h
 
 	dsm := DataStreamMessage{
 		Op: DataStreamOp{
 			OpID: recvMsg.Op.OpID,
-			Type: WSOpType.delete,
+			Type: WSOpType.helmTemplate,
 		},
 	}
 
-	_, kubeErr := ds.kubeDelete()
-	if kubeErr != nil {
-		dsm.Error = kubeErr.Error()
+	data, helmErr := ds.helmTemplate(config, ds.recvMsg.Op.Request.HelmOptions)
+	if helmErr != nil {
+		dsm.Error = helmErr.Error()
 	}
 
-	if err := dss.ws.WriteJSON(dsm); err != nil {
+	dsm.Data = string(data)
+
+	if err := dss.WriteJSON(dsm); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (dss DataStreamSession) ShowValuesHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
+func (dss DataStreamSession) GetManifestHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
 	var ds DataStream
 	ds.recvMsg = recvMsg
 	ds.helm = h
@@ -639,25 +1777,25 @@ func (dss DataStreamSession) ShowValuesHelm(recvMsg DataStreamMessage, config *r
 	dsm := DataStreamMessage{
 		Op: DataStreamOp{
 			OpID: recvMsg.Op.OpID,
-			Type: WSOpType.helmShowValues,
+			Type: WSOpType.helmGetManifest,
 		},
 	}
 
-	data, helmErr := ds.helmShowValues(config)
+	data, helmErr := ds.helmGetManifest(config, ds.recvMsg.Op.Request.HelmOptions)
 	if helmErr != nil {
 		dsm.Error = helmErr.Error()
 	}
 
-	dsm.Data = data
+	dsm.Data = string(data)
 
-	if err := dss.ws.WriteJSON(dsm); err != nil {
+	if err := dss.WriteJSON(dsm); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (dss DataStreamSession) ListHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
+func (dss DataStreamSession) ValuesHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
 	var ds DataStream
 	ds.recvMsg = recvMsg
 	ds.helm = h
@@ -665,25 +1803,25 @@ func (dss DataStreamSession) ListHelm(recvMsg DataStreamMessage, config *rest.Co
 	dsm := DataStreamMessage{
 		Op: DataStreamOp{
 			OpID: recvMsg.Op.OpID,
-			Type: WSOpType.helmList,
+			Type: recvMsg.Op.Type,
 		},
 	}
 
-	data, helmErr := ds.helmList(config)
+	data, helmErr := ds.helmValues(config, ds.recvMsg.Op.Request.HelmOptions)
 	if helmErr != nil {
 		dsm.Error = helmErr.Error()
 	}
 
 	dsm.Data = string(data)
 
-	if err := dss.ws.WriteJSON(dsm); err != nil {
+	if err := dss.WriteJSON(dsm); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (dss DataStreamSession) GetHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
+func (dss DataStreamSession) HistoryHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
 	var ds DataStream
 	ds.recvMsg = recvMsg
 	ds.helm = h
@@ -691,25 +1829,31 @@ func (dss DataStreamSession) GetHelm(recvMsg DataStreamMessage, config *rest.Con
 	dsm := DataStreamMessage{
 		Op: DataStreamOp{
 			OpID: recvMsg.Op.OpID,
-			Type: WSOpType.helmGet,
+			Type: WSOpType.helmHistory,
 		},
 	}
 
-	data, helmErr := ds.helmGet(config)
+	data, helmErr := ds.helmHistory(config)
 	if helmErr != nil {
 		dsm.Error = helmErr.Error()
 	}
 
 	dsm.Data = string(data)
 
-	if err := dss.ws.WriteJSON(dsm); err != nil {
+	if err := dss.WriteJSON(dsm); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (dss DataStreamSession) PullHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
+func (dss DataStreamSession) RollbackHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
+	return dss.runHelmJob(recvMsg, h, false, func(ds DataStream) ([]byte, error) {
+		return ds.helmRollback(config, ds.recvMsg.Op.Request.HelmOptions)
+	})
+}
+
+func (dss DataStreamSession) RepoHelm(recvMsg DataStreamMessage, h *Helm) error {
 	var ds DataStream
 	ds.recvMsg = recvMsg
 	ds.helm = h
@@ -717,25 +1861,25 @@ func (dss DataStreamSession) PullHelm(recvMsg DataStreamMessage, config *rest.Co
 	dsm := DataStreamMessage{
 		Op: DataStreamOp{
 			OpID: recvMsg.Op.OpID,
-			Type: WSOpType.helmPull,
+			Type: recvMsg.Op.Type,
 		},
 	}
 
-	data, helmErr := ds.helmPull(config)
+	data, helmErr := ds.helmRepo()
 	if helmErr != nil {
 		dsm.Error = helmErr.Error()
 	}
 
 	dsm.Data = string(data)
 
-	if err := dss.ws.WriteJSON(dsm); err != nil {
+	if err := dss.WriteJSON(dsm); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (dss DataStreamSession) GetHelmTags(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
+func (dss DataStreamSession) DependencyUpdateHelm(recvMsg DataStreamMessage, h *Helm) error {
 	var ds DataStream
 	ds.recvMsg = recvMsg
 	ds.helm = h
@@ -743,30 +1887,51 @@ func (dss DataStreamSession) GetHelmTags(recvMsg DataStreamMessage, config *rest
 	dsm := DataStreamMessage{
 		Op: DataStreamOp{
 			OpID: recvMsg.Op.OpID,
-			Type: WSOpType.helmGetTags,
+			Type: WSOpType.helmDependencyUpdate,
 		},
 	}
 
-	data, helmErr := ds.helmChartTags()
+	data, helmErr := ds.helmDependencyUpdate()
 	if helmErr != nil {
 		dsm.Error = helmErr.Error()
 	}
 
-	b, err := json.Marshal(data)
-	if err != nil {
+	dsm.Data = string(data)
+
+	if err := dss.WriteJSON(dsm); err != nil {
 		return err
 	}
 
-	dsm.Data = string(b)
+	return nil
+}
+
+func (dss DataStreamSession) LintHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
+	var ds DataStream
+	ds.recvMsg = recvMsg
+	ds.helm = h
+
+	dsm := DataStreamMessage{
+		Op: DataStreamOp{
+			OpID: recvMsg.Op.OpID,
+			Type: WSOpType.helmLint,
+		},
+	}
+
+	data, helmErr := ds.helmLint(config)
+	if helmErr != nil {
+		dsm.Error = helmErr.Error()
+	}
+
+	dsm.Data = string(data)
 
-	if err := dss.ws.WriteJSON(dsm); err != nil {
+	if err := dss.WriteJSON(dsm); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (dss DataStreamSession) InstallHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
+func (dss DataStreamSession) SearchHelm(recvMsg DataStreamMessage, h *Helm) error {
 	var ds DataStream
 	ds.recvMsg = recvMsg
 	ds.helm = h
@@ -774,25 +1939,25 @@ func (dss DataStreamSession) InstallHelm(recvMsg DataStreamMessage, config *rest
 	dsm := DataStreamMessage{
 		Op: DataStreamOp{
 			OpID: recvMsg.Op.OpID,
-			Type: WSOpType.helmInstall,
+			Type: WSOpType.helmSearch,
 		},
 	}
 
-	data, helmErr := ds.helmInstall(config, ds.recvMsg.Op.Request.HelmOptions)
+	data, helmErr := ds.helmSearch()
 	if helmErr != nil {
 		dsm.Error = helmErr.Error()
 	}
 
 	dsm.Data = string(data)
 
-	if err := dss.ws.WriteJSON(dsm); err != nil {
+	if err := dss.WriteJSON(dsm); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (dss DataStreamSession) UpgradeHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
+func (dss DataStreamSession) RegistryHelm(recvMsg DataStreamMessage, h *Helm) error {
 	var ds DataStream
 	ds.recvMsg = recvMsg
 	ds.helm = h
@@ -800,25 +1965,31 @@ func (dss DataStreamSession) UpgradeHelm(recvMsg DataStreamMessage, config *rest
 	dsm := DataStreamMessage{
 		Op: DataStreamOp{
 			OpID: recvMsg.Op.OpID,
-			Type: WSOpType.helmUpgrade,
+			Type: recvMsg.Op.Type,
 		},
 	}
 
-	data, helmErr := ds.helmUpgrade(config, ds.recvMsg.Op.Request.HelmOptions)
+	data, helmErr := ds.helmRegistry()
 	if helmErr != nil {
 		dsm.Error = helmErr.Error()
 	}
 
-	dsm.Data = string(data)
+	dsm.Data = data
 
-	if err := dss.ws.WriteJSON(dsm); err != nil {
+	if err := dss.WriteJSON(dsm); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (dss DataStreamSession) UninstallHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
+func (dss DataStreamSession) TestHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
+	return dss.runHelmJob(recvMsg, h, false, func(ds DataStream) ([]byte, error) {
+		return ds.helmTest(config, ds.recvMsg.Op.Request.HelmOptions)
+	})
+}
+
+func (dss DataStreamSession) StatusHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
 	var ds DataStream
 	ds.recvMsg = recvMsg
 	ds.helm = h
@@ -826,26 +1997,34 @@ func (dss DataStreamSession) UninstallHelm(recvMsg DataStreamMessage, config *re
 	dsm := DataStreamMessage{
 		Op: DataStreamOp{
 			OpID: recvMsg.Op.OpID,
-			Type: WSOpType.helmUninstall,
+			Type: WSOpType.helmStatus,
 		},
 	}
 
-	data, err := ds.helmUninstall(config)
-	if err != nil {
-		dsm.Error = err.Error()
+	data, helmErr := ds.helmStatus(config, ds.recvMsg.Op.Request.HelmOptions)
+	if helmErr != nil {
+		dsm.Error = helmErr.Error()
 	}
 
-	if dsm.Error == "" {
-		dsm.Data = data.Release.Info.Description
-	}
+	dsm.Data = string(data)
 
-	if err := dss.ws.WriteJSON(dsm); err != nil {
+	if err := dss.WriteJSON(dsm); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+func (dss DataStreamSession) UninstallHelm(recvMsg DataStreamMessage, config *rest.Config, h *Helm) error {
+	return dss.runHelmJob(recvMsg, h, false, func(ds DataStream) ([]byte, error) {
+		urr, err := ds.helmUninstall(config)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(urr.Release.Info.Description), nil
+	})
+}
+
 var dataSessions = DataSessionMap{Sessions: make(map[string]DataStreamSession)}
 
 func handleDataStreamSession(ws *websocket.Conn) {
@@ -879,7 +2058,7 @@ func handleDataStreamSession(ws *websocket.Conn) {
 		},
 	}
 
-	if senderr := dss.ws.WriteJSON(sendMsg); senderr != nil {
+	if senderr := dss.WriteJSON(sendMsg); senderr != nil {
 		fmt.Println("handleStreamData senderr:", senderr)
 		return
 	}
@@ -912,6 +2091,14 @@ func startDataStream(ar *APIResource, dss DataStreamSession, closeChan chan stru
 			if err := dss.ListAll(dsm, ar.DynamicClient); err != nil {
 				return err
 			}
+		case dsm.Op.Type == WSOpType.watch && dss.id == dsm.SessionID:
+			if err := dss.Watch(dsm, ar.DynamicClient); err != nil {
+				return err
+			}
+		case dsm.Op.Type == WSOpType.unwatch && dss.id == dsm.SessionID:
+			if err := dss.Unwatch(dsm); err != nil {
+				return err
+			}
 		case dsm.Op.Type == WSOpType.get && dss.id == dsm.SessionID:
 			if err := dss.Get(dsm, ar.DynamicClient); err != nil {
 				return err
@@ -920,6 +2107,18 @@ func startDataStream(ar *APIResource, dss DataStreamSession, closeChan chan stru
 			if err := dss.Update(dsm, ar.DynamicClient); err != nil {
 				return err
 			}
+		case (dsm.Op.Type == WSOpType.create || dsm.Op.Type == WSOpType.apply) && dss.id == dsm.SessionID:
+			if err := dss.Manifests(dsm, ar.DynamicClient, ar.RESTMapper); err != nil {
+				return err
+			}
+		case dsm.Op.Type == WSOpType.patch && dss.id == dsm.SessionID:
+			if err := dss.Patch(dsm, ar.DynamicClient); err != nil {
+				return err
+			}
+		case dsm.Op.Type == WSOpType.diff && dss.id == dsm.SessionID:
+			if err := dss.Diff(dsm, ar.DynamicClient, ar.RESTMapper); err != nil {
+				return err
+			}
 		case dsm.Op.Type == WSOpType.delete && dss.id == dsm.SessionID:
 			if err := dss.Delete(dsm, ar.DynamicClient); err != nil {
 				return err
@@ -944,6 +2143,23 @@ func startDataStream(ar *APIResource, dss DataStreamSession, closeChan chan stru
 			if err := dss.UpgradeHelm(dsm, ar.Config, ar.Helm); err != nil {
 				return err
 			}
+		case dsm.Op.Type == WSOpType.helmDiff && dss.id == dsm.SessionID:
+			if err := dss.DiffHelm(dsm, ar.Config, ar.Helm); err != nil {
+				return err
+			}
+		case dsm.Op.Type == WSOpType.helmTemplate && dss.id == dsm.SessionID:
+			if err := dss.TemplateHelm(dsm, ar.Config, ar.Helm); err != nil {
+				return err
+			}
+		case dsm.Op.Type == WSOpType.helmGetManifest && dss.id == dsm.SessionID:
+			if err := dss.GetManifestHelm(dsm, ar.Config, ar.Helm); err != nil {
+				return err
+			}
+		case (dsm.Op.Type == WSOpType.helmValidateValues ||
+			dsm.Op.Type == WSOpType.helmValuesDiff) && dss.id == dsm.SessionID:
+			if err := dss.ValuesHelm(dsm, ar.Config, ar.Helm); err != nil {
+				return err
+			}
 		case dsm.Op.Type == WSOpType.helmPull && dss.id == dsm.SessionID:
 			if err := dss.PullHelm(dsm, ar.Config, ar.Helm); err != nil {
 				return err
@@ -952,6 +2168,51 @@ func startDataStream(ar *APIResource, dss DataStreamSession, closeChan chan stru
 			if err := dss.GetHelmTags(dsm, ar.Config, ar.Helm); err != nil {
 				return err
 			}
+		case dsm.Op.Type == WSOpType.helmDependencyUpdate && dss.id == dsm.SessionID:
+			if err := dss.DependencyUpdateHelm(dsm, ar.Helm); err != nil {
+				return err
+			}
+		case dsm.Op.Type == WSOpType.helmLint && dss.id == dsm.SessionID:
+			if err := dss.LintHelm(dsm, ar.Config, ar.Helm); err != nil {
+				return err
+			}
+		case dsm.Op.Type == WSOpType.helmSearch && dss.id == dsm.SessionID:
+			if err := dss.SearchHelm(dsm, ar.Helm); err != nil {
+				return err
+			}
+		case dsm.Op.Type == WSOpType.helmHistory && dss.id == dsm.SessionID:
+			if err := dss.HistoryHelm(dsm, ar.Config, ar.Helm); err != nil {
+				return err
+			}
+		case dsm.Op.Type == WSOpType.helmRollback && dss.id == dsm.SessionID:
+			if err := dss.RollbackHelm(dsm, ar.Config, ar.Helm); err != nil {
+				return err
+			}
+		case (dsm.Op.Type == WSOpType.helmRepoAdd ||
+			dsm.Op.Type == WSOpType.helmRepoList ||
+			dsm.Op.Type == WSOpType.helmRepoUpdate ||
+			dsm.Op.Type == WSOpType.helmRepoRemove) && dss.id == dsm.SessionID:
+			if err := dss.RepoHelm(dsm, ar.Helm); err != nil {
+				return err
+			}
+		case (dsm.Op.Type == WSOpType.helmRegistryLogin ||
+			dsm.Op.Type == WSOpType.helmRegistryLogout) && dss.id == dsm.SessionID:
+			if err := dss.RegistryHelm(dsm, ar.Helm); err != nil {
+				return err
+			}
+		case dsm.Op.Type == WSOpType.helmTest && dss.id == dsm.SessionID:
+			if err := dss.TestHelm(dsm, ar.Config, ar.Helm); err != nil {
+				return err
+			}
+		case dsm.Op.Type == WSOpType.helmStatus && dss.id == dsm.SessionID:
+			if err := dss.StatusHelm(dsm, ar.Config, ar.Helm); err != nil {
+				return err
+			}
+		case (dsm.Op.Type == WSOpType.helmJobStatus ||
+			dsm.Op.Type == WSOpType.helmJobCancel) && dss.id == dsm.SessionID:
+			if err := dss.JobHelm(dsm, ar.Helm); err != nil {
+				return err
+			}
 		case dsm.Op.Type == WSOpType.helmUninstall && dss.id == dsm.SessionID:
 			if err := dss.UninstallHelm(dsm, ar.Config, ar.Helm); err != nil {
 				return err
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+var FieldChangeOp = struct {
+	add,
+	remove,
+	replace string
+}{
+	add:     "add",
+	remove:  "remove",
+	replace: "replace",
+}
+
+type FieldChange struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+
+	segments []interface{}
+}
+
+var plainPathKey = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$-]*$`)
+
+func formatFieldPath(segments []interface{}) string {
+	var sb strings.Builder
+	for _, seg := range segments {
+		switch v := seg.(type) {
+		case int:
+			fmt.Fprintf(&sb, "[%d]", v)
+		case string:
+			if plainPathKey.MatchString(v) {
+				sb.WriteString("." + v)
+			} else {
+				fmt.Fprintf(&sb, "[%q]", v)
+			}
+		}
+	}
+	if sb.Len() == 0 {
+		return "."
+	}
+	return sb.String()
+}
+
+func appendSegment(segments []interface{}, seg interface{}) []interface{} {
+	next := make([]interface{}, len(segments), len(segments)+1)
+	copy(next, segments)
+	return append(next, seg)
+}
+
+// diffValues returns the changes needed to turn from into to. Lists are
+// compared element by element when their length did not change, otherwise the
+// whole list is reported as replaced.
+func diffValues(segments []interface{}, from, to interface{}) []FieldChange {
+	switch fromV := from.(type) {
+	case map[string]interface{}:
+		toV, ok := to.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := make([]string, 0, len(fromV)+len(toV))
+		for k := range fromV {
+			keys = append(keys, k)
+		}
+		for k := range toV {
+			if _, ok := fromV[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		var changes []FieldChange
+		for _, k := range keys {
+			path := appendSegment(segments, k)
+			fv, inFrom := fromV[k]
+			tv, inTo := toV[k]
+			switch {
+			case !inTo:
+				changes = append(changes, FieldChange{Op: FieldChangeOp.remove, From: fv, segments: path})
+			case !inFrom:
+				changes = append(changes, FieldChange{Op: FieldChangeOp.add, To: tv, segments: path})
+			default:
+				changes = append(changes, diffValues(path, fv, tv)...)
+			}
+		}
+		return changes
+	case []interface{}:
+		toV, ok := to.([]interface{})
+		if !ok || len(fromV) != len(toV) {
+			break
+		}
+
+		var changes []FieldChange
+		for i := range fromV {
+			changes = append(changes, diffValues(appendSegment(segments, i), fromV[i], toV[i])...)
+		}
+		return changes
+	}
+
+	if reflect.DeepEqual(from, to) {
+		return nil
+	}
+	return []FieldChange{{Op: FieldChangeOp.replace, From: from, To: to, segments: segments}}
+}
+
+func diffObjects(from, to map[string]interface{}) []FieldChange {
+	changes := diffValues(nil, normalizeForDiff(from), normalizeForDiff(to))
+	for i := range changes {
+		changes[i].Path = formatFieldPath(changes[i].segments)
+	}
+	return changes
+}
+
+// normalizeForDiff drops the fields that are maintained by the API server and
+// would only add noise to a diff.
+func normalizeForDiff(obj map[string]interface{}) map[string]interface{} {
+	if obj == nil {
+		return map[string]interface{}{}
+	}
+
+	out := runtime.DeepCopyJSON(obj)
+	if md, ok := out["metadata"].(map[string]interface{}); ok {
+		delete(md, "resourceVersion")
+		delete(md, "managedFields")
+		delete(md, "generation")
+	}
+	delete(out, "status")
+
+	return out
+}
+
+func pathsOverlap(a, b []interface{}) bool {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// conflictingChanges returns the paths that were changed on both sides to
+// different values.
+func conflictingChanges(local, remote []FieldChange) []string {
+	conflicts := []string{}
+	for _, lc := range local {
+		for _, rc := range remote {
+			if !pathsOverlap(lc.segments, rc.segments) {
+				continue
+			}
+			if lc.Path == rc.Path && lc.Op == rc.Op && reflect.DeepEqual(lc.To, rc.To) {
+				continue
+			}
+			conflicts = append(conflicts, lc.Path)
+			break
+		}
+	}
+	return conflicts
+}
+
+func applyFieldChange(obj interface{}, segments []interface{}, fc FieldChange) (interface{}, error) {
+	if len(segments) == 0 {
+		if fc.Op == FieldChangeOp.remove {
+			return nil, nil
+		}
+		return runtime.DeepCopyJSONValue(fc.To), nil
+	}
+
+	switch seg := segments[0].(type) {
+	case string:
+		m, ok := obj.(map[string]interface{})
+		if !ok {
+			if obj != nil {
+				return nil, fmt.Errorf("cannot apply change to '%s': not an object", fc.Path)
+			}
+			m = map[string]interface{}{}
+		}
+		if len(segments) == 1 && fc.Op == FieldChangeOp.remove {
+			delete(m, seg)
+			return m, nil
+		}
+		v, err := applyFieldChange(m[seg], segments[1:], fc)
+		if err != nil {
+			return nil, err
+		}
+		m[seg] = v
+		return m, nil
+	case int:
+		l, ok := obj.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cannot apply change to '%s': not a list", fc.Path)
+		}
+		if seg >= len(l) {
+			return nil, fmt.Errorf("cannot apply change to '%s': index %d out of range", fc.Path, seg)
+		}
+		v, err := applyFieldChange(l[seg], segments[1:], fc)
+		if err != nil {
+			return nil, err
+		}
+		l[seg] = v
+		return l, nil
+	}
+
+	return nil, fmt.Errorf("cannot apply change to '%s'", fc.Path)
+}
+
+// applyFieldChanges replays the changes on a copy of obj, a change whose path
+// does not exist in obj anymore is an error.
+func applyFieldChanges(obj map[string]interface{}, changes []FieldChange) (map[string]interface{}, error) {
+	var out interface{} = runtime.DeepCopyJSON(obj)
+	for _, fc := range changes {
+		var err error
+		if out, err = applyFieldChange(out, fc.segments, fc); err != nil {
+			return nil, err
+		}
+	}
+	return out.(map[string]interface{}), nil
+}
+
+const (
+	unifiedDiffContext = 3
+	// maxEditDistance bounds the memory used by the line diff, larger changes
+	// are reported as a full replacement.
+	maxEditDistance = 2048
+)
+
+type lineOp struct {
+	kind byte
+	line string
+}
+
+// diffLines implements the Myers diff algorithm on lines.
+func diffLines(a, b []string) []lineOp {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+	found := false
+	for d := 0; d <= n+m && d <= maxEditDistance; d++ {
+		// Only the diagonals reachable with d edits are kept for backtracking.
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+		if found {
+			break
+		}
+	}
+
+	if !found {
+		ops := make([]lineOp, 0, n+m)
+		for _, line := range a {
+			ops = append(ops, lineOp{kind: '-', line: line})
+		}
+		for _, line := range b {
+			ops = append(ops, lineOp{kind: '+', line: line})
+		}
+		return ops
+	}
+
+	var ops []lineOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		tv := trace[d]
+		k := x - y
+
+		prevX, prevY := 0, 0
+		if d > 0 {
+			prevK := k - 1
+			if k == -d || (k != d && tv[d+k-1] < tv[d+k+1]) {
+				prevK = k + 1
+			}
+			prevX = tv[d+prevK]
+			prevY = prevX - prevK
+		}
+
+		for x > prevX && y > prevY {
+			ops = append(ops, lineOp{kind: ' ', line: a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, lineOp{kind: '+', line: b[y-1]})
+			} else {
+				ops = append(ops, lineOp{kind: '-', line: a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+
+	return ops
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// unifiedDiff renders the difference between two texts in the unified diff
+// format, an empty string is returned when they are equal.
+func unifiedDiff(fromName, toName, from, to string) string {
+	ops := diffLines(splitLines(from), splitLines(to))
+
+	var sb strings.Builder
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		start := max(i-unifiedDiffContext, 0)
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*unifiedDiffContext {
+				end = min(end+unifiedDiffContext, len(ops))
+				break
+			}
+			end = next
+		}
+
+		fromLine, toLine := 1, 1
+		for _, op := range ops[:start] {
+			if op.kind != '+' {
+				fromLine++
+			}
+			if op.kind != '-' {
+				toLine++
+			}
+		}
+		fromCount, toCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				fromCount++
+			}
+			if op.kind != '-' {
+				toCount++
+			}
+		}
+		if fromCount == 0 {
+			fromLine--
+		}
+		if toCount == 0 {
+			toLine--
+		}
+
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", fromLine, fromCount, toLine, toCount)
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			sb.WriteByte('\n')
+		}
+
+		i = end
+	}
+
+	return sb.String()
+}
+
+var DiffAction = struct {
+	create,
+	update,
+	delete,
+	unchanged string
+}{
+	create:    "create",
+	update:    "update",
+	delete:    "delete",
+	unchanged: "unchanged",
+}
+
+type ResourceDiff struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Namespace  string        `json:"namespace"`
+	Name       string        `json:"name"`
+	Action     string        `json:"action"`
+	Unified    string        `json:"unified"`
+	Changes    []FieldChange `json:"changes"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// diffResource compares two versions of the same object, either of them may
+// be nil when the object is created or deleted.
+func diffResource(from, to *unstructured.Unstructured) ResourceDiff {
+	var rd ResourceDiff
+	var fromObj, toObj map[string]interface{}
+
+	for _, obj := range []*unstructured.Unstructured{to, from} {
+		if obj != nil {
+			rd.APIVersion = obj.GetAPIVersion()
+			rd.Kind = obj.GetKind()
+			rd.Namespace = obj.GetNamespace()
+			rd.Name = obj.GetName()
+			break
+		}
+	}
+	if from != nil {
+		fromObj = normalizeForDiff(from.Object)
+	}
+	if to != nil {
+		toObj = normalizeForDiff(to.Object)
+	}
+
+	var fromYAML, toYAML []byte
+	var err error
+	if fromObj != nil {
+		if fromYAML, err = yaml.Marshal(fromObj); err != nil {
+			rd.Error = err.Error()
+			return rd
+		}
+	}
+	if toObj != nil {
+		if toYAML, err = yaml.Marshal(toObj); err != nil {
+			rd.Error = err.Error()
+			return rd
+		}
+	}
+
+	rd.Changes = diffObjects(fromObj, toObj)
+	rd.Unified = unifiedDiff("live", "result", string(fromYAML), string(toYAML))
+
+	switch {
+	case from == nil:
+		rd.Action = DiffAction.create
+	case to == nil:
+		rd.Action = DiffAction.delete
+	case len(rd.Changes) == 0:
+		rd.Action = DiffAction.unchanged
+	default:
+		rd.Action = DiffAction.update
+	}
+
+	return rd
+}
+
+func manifestKey(obj *unstructured.Unstructured) string {
+	return strings.Join([]string{obj.GroupVersionKind().GroupKind().String(), obj.GetNamespace(), obj.GetName()}, "/")
+}
+
+// diffManifests compares two multi-document manifests resource by resource.
+func diffManifests(from, to string) ([]ResourceDiff, error) {
+	fromObjs, err := decodeManifests([]byte(from))
+	if err != nil {
+		return nil, err
+	}
+	toObjs, err := decodeManifests([]byte(to))
+	if err != nil {
+		return nil, err
+	}
+
+	fromByKey := make(map[string]*unstructured.Unstructured, len(fromObjs))
+	for _, obj := range fromObjs {
+		fromByKey[manifestKey(obj)] = obj
+	}
+
+	diffs := []ResourceDiff{}
+	seen := make(map[string]bool, len(toObjs))
+	for _, obj := range toObjs {
+		key := manifestKey(obj)
+		seen[key] = true
+		diffs = append(diffs, diffResource(fromByKey[key], obj))
+	}
+	for _, obj := range fromObjs {
+		if !seen[manifestKey(obj)] {
+			diffs = append(diffs, diffResource(obj, nil))
+		}
+	}
+
+	return diffs, nil
+}
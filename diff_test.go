@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func formatLineOps(ops []lineOp) string {
+	lines := make([]string, 0, len(ops))
+	for _, op := range ops {
+		lines = append(lines, string(op.kind)+op.line)
+	}
+	return strings.Join(lines, "|")
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want string
+	}{
+		{
+			name: "empty",
+			want: "",
+		},
+		{
+			name: "identical",
+			a:    []string{"a", "b", "c"},
+			b:    []string{"a", "b", "c"},
+			want: " a| b| c",
+		},
+		{
+			name: "insert into empty",
+			b:    []string{"a", "b"},
+			want: "+a|+b",
+		},
+		{
+			name: "delete everything",
+			a:    []string{"a", "b"},
+			want: "-a|-b",
+		},
+		{
+			name: "pure insert",
+			a:    []string{"a", "c"},
+			b:    []string{"a", "b", "c"},
+			want: " a|+b| c",
+		},
+		{
+			name: "pure delete",
+			a:    []string{"a", "b", "c"},
+			b:    []string{"a", "c"},
+			want: " a|-b| c",
+		},
+		{
+			name: "append",
+			a:    []string{"a"},
+			b:    []string{"a", "b"},
+			want: " a|+b",
+		},
+		{
+			name: "replace",
+			a:    []string{"a", "b", "c"},
+			b:    []string{"a", "x", "c"},
+			want: " a|-b|+x| c",
+		},
+		{
+			name: "moved line",
+			a:    []string{"a", "b", "c"},
+			b:    []string{"b", "c", "a"},
+			want: "-a| b| c|+a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatLineOps(diffLines(tt.a, tt.b)); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesMaxEditDistance(t *testing.T) {
+	// Every line differs, so more than maxEditDistance edits are needed.
+	var a, b []string
+	for i := 0; i <= maxEditDistance/2; i++ {
+		a = append(a, fmt.Sprintf("a%d", i))
+		b = append(b, fmt.Sprintf("b%d", i))
+	}
+	a = append(a, "same")
+	b = append(b, "same")
+
+	ops := diffLines(a, b)
+	if len(ops) != len(a)+len(b) {
+		t.Fatalf("got %d ops, want %d", len(ops), len(a)+len(b))
+	}
+	for i, op := range ops {
+		var want lineOp
+		if i < len(a) {
+			want = lineOp{kind: '-', line: a[i]}
+		} else {
+			want = lineOp{kind: '+', line: b[i-len(a)]}
+		}
+		if op != want {
+			t.Fatalf("op %d: got %c%s, want the full replacement %c%s", i, op.kind, op.line, want.kind, want.line)
+		}
+	}
+
+	// Within the bound the common line is still found.
+	ops = diffLines(a[len(a)-3:], b[len(b)-3:])
+	if got, want := formatLineOps(ops), fmt.Sprintf("-%s|-%s|+%s|+%s| same", a[len(a)-3], a[len(a)-2], b[len(b)-3], b[len(b)-2]); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	long := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{
+			name: "empty",
+			want: "",
+		},
+		{
+			name: "identical",
+			from: "a\nb\n",
+			to:   "a\nb\n",
+			want: "",
+		},
+		{
+			name: "missing trailing newline",
+			from: "a\nb",
+			to:   "a\nb\n",
+			want: "",
+		},
+		{
+			name: "created",
+			to:   "a\nb\n",
+			want: "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "deleted",
+			from: "a\nb\n",
+			want: "--- old\n+++ new\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			name: "pure insert",
+			from: "a\nb\nc\n",
+			to:   "a\nb\nx\nc\n",
+			want: "--- old\n+++ new\n@@ -1,3 +1,4 @@\n a\n b\n+x\n c\n",
+		},
+		{
+			name: "pure delete",
+			from: "a\nb\nc\n",
+			to:   "a\nc\n",
+			want: "--- old\n+++ new\n@@ -1,3 +1,2 @@\n a\n-b\n c\n",
+		},
+		{
+			name: "context is limited",
+			from: long,
+			to:   strings.Replace(long, "\n6\n", "\nsix\n", 1),
+			want: "--- old\n+++ new\n@@ -3,7 +3,7 @@\n 3\n 4\n 5\n-6\n+six\n 7\n 8\n 9\n",
+		},
+		{
+			name: "separate hunks",
+			from: long,
+			to:   strings.Replace(strings.Replace(long, "1\n", "one\n", 1), "\n12\n", "\ntwelve\n", 1),
+			want: "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n" +
+				"@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			name: "close changes share a hunk",
+			from: long,
+			to:   strings.Replace(strings.Replace(long, "\n3\n", "\nthree\n", 1), "\n8\n", "\neight\n", 1),
+			want: "--- old\n+++ new\n@@ -1,11 +1,11 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n 7\n-8\n+eight\n 9\n 10\n 11\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("old", "new", tt.from, tt.to); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConflictingChanges(t *testing.T) {
+	base := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":   "web",
+			"labels": map[string]interface{}{"app": "web"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(1),
+			"ports":    []interface{}{int64(80), int64(443)},
+		},
+	}
+
+	with := func(edit func(obj map[string]interface{})) map[string]interface{} {
+		obj := normalizeForDiff(base)
+		edit(obj)
+		return obj
+	}
+	spec := func(obj map[string]interface{}) map[string]interface{} {
+		return obj["spec"].(map[string]interface{})
+	}
+	labels := func(obj map[string]interface{}) map[string]interface{} {
+		return obj["metadata"].(map[string]interface{})["labels"].(map[string]interface{})
+	}
+
+	tests := []struct {
+		name          string
+		local, remote map[string]interface{}
+		want          []string
+	}{
+		{
+			name:   "no changes",
+			local:  base,
+			remote: base,
+			want:   []string{},
+		},
+		{
+			name:   "only local changes",
+			local:  with(func(obj map[string]interface{}) { spec(obj)["replicas"] = int64(3) }),
+			remote: base,
+			want:   []string{},
+		},
+		{
+			name:   "different fields",
+			local:  with(func(obj map[string]interface{}) { spec(obj)["replicas"] = int64(3) }),
+			remote: with(func(obj map[string]interface{}) { labels(obj)["tier"] = "frontend" }),
+			want:   []string{},
+		},
+		{
+			name:   "same change",
+			local:  with(func(obj map[string]interface{}) { spec(obj)["replicas"] = int64(3) }),
+			remote: with(func(obj map[string]interface{}) { spec(obj)["replicas"] = int64(3) }),
+			want:   []string{},
+		},
+		{
+			name:   "same field, different values",
+			local:  with(func(obj map[string]interface{}) { spec(obj)["replicas"] = int64(3) }),
+			remote: with(func(obj map[string]interface{}) { spec(obj)["replicas"] = int64(5) }),
+			want:   []string{".spec.replicas"},
+		},
+		{
+			name:   "added on both sides",
+			local:  with(func(obj map[string]interface{}) { labels(obj)["tier"] = "frontend" }),
+			remote: with(func(obj map[string]interface{}) { labels(obj)["tier"] = "backend" }),
+			want:   []string{".metadata.labels.tier"},
+		},
+		{
+			name:   "removed and changed",
+			local:  with(func(obj map[string]interface{}) { delete(spec(obj), "replicas") }),
+			remote: with(func(obj map[string]interface{}) { spec(obj)["replicas"] = int64(5) }),
+			want:   []string{".spec.replicas"},
+		},
+		{
+			name:   "parent removed, child changed",
+			local:  with(func(obj map[string]interface{}) { delete(obj["metadata"].(map[string]interface{}), "labels") }),
+			remote: with(func(obj map[string]interface{}) { labels(obj)["app"] = "api" }),
+			want:   []string{".metadata.labels"},
+		},
+		{
+			name:   "child changed, parent removed",
+			local:  with(func(obj map[string]interface{}) { labels(obj)["app"] = "api" }),
+			remote: with(func(obj map[string]interface{}) { delete(obj["metadata"].(map[string]interface{}), "labels") }),
+			want:   []string{".metadata.labels.app"},
+		},
+		{
+			name:   "list replaced, element changed",
+			local:  with(func(obj map[string]interface{}) { spec(obj)["ports"] = []interface{}{int64(8080)} }),
+			remote: with(func(obj map[string]interface{}) { spec(obj)["ports"].([]interface{})[1] = int64(8443) }),
+			want:   []string{".spec.ports"},
+		},
+		{
+			name:   "different list elements",
+			local:  with(func(obj map[string]interface{}) { spec(obj)["ports"].([]interface{})[0] = int64(8080) }),
+			remote: with(func(obj map[string]interface{}) { spec(obj)["ports"].([]interface{})[1] = int64(8443) }),
+			want:   []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local := diffObjects(base, tt.local)
+			remote := diffObjects(base, tt.remote)
+			if got := conflictingChanges(local, remote); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
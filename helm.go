@@ -10,20 +10,30 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/labstack/gommon/log"
 	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
 	"helm.sh/helm/v3/pkg/cli"
 	"helm.sh/helm/v3/pkg/downloader"
 	"helm.sh/helm/v3/pkg/getter"
 	"helm.sh/helm/v3/pkg/registry"
 	"helm.sh/helm/v3/pkg/release"
 	"helm.sh/helm/v3/pkg/repo"
-	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"oras.land/oras-go/v2/registry/remote"
 	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/credentials"
 	"sigs.k8s.io/yaml"
 )
 
@@ -47,6 +57,9 @@ func initHelm(ar *APIResource) (*action.Configuration, *cli.EnvSettings, error)
 	settings.KubeToken = ar.Config.BearerToken
 	settings.KubeCaFile = ar.Config.CAFile
 	settings.KubeInsecureSkipTLSVerify = ar.Config.Insecure
+	settings.KubeTLSServerName = ar.Config.ServerName
+	settings.KubeAsUser = ar.Config.Impersonate.UserName
+	settings.KubeAsGroups = ar.Config.Impersonate.Groups
 	settings.RegistryConfig = filepath.Join(homeDir, defaultHelmRegistry, "config.json")
 	settings.RepositoryCache = filepath.Join(homeDir, defaultHelmRepository)
 	settings.RepositoryConfig = filepath.Join(homeDir, defaultHelmRepository, "repositories.yaml")
@@ -54,19 +67,70 @@ func initHelm(ar *APIResource) (*action.Configuration, *cli.EnvSettings, error)
 	return actionConfig, settings, nil
 }
 
-func GetActionConfig(ctx context.Context, namespace string, config *rest.Config) (*action.Configuration, error) {
-	actionConfig := new(action.Configuration)
-	cliConfig := genericclioptions.NewConfigFlags(false)
-	cliConfig.APIServer = &config.Host
-	cliConfig.BearerToken = &config.BearerToken
-	cliConfig.Namespace = &namespace
+// restConfigGetter hands the cluster rest.Config to helm as is, so exec
+// plugins, auth providers, client certificates, CA data, proxy and
+// impersonation settings are used for helm actions the same way they are for
+// browsing resources.
+type restConfigGetter struct {
+	config    *rest.Config
+	namespace string
+}
+
+func (rcg *restConfigGetter) ToRESTConfig() (*rest.Config, error) {
+	return rest.CopyConfig(rcg.config), nil
+}
+
+func (rcg *restConfigGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(rest.CopyConfig(rcg.config))
+	if err != nil {
+		return nil, err
+	}
+	return memory.NewMemCacheClient(dc), nil
+}
+
+func (rcg *restConfigGetter) ToRESTMapper() (meta.RESTMapper, error) {
+	dc, err := rcg.ToDiscoveryClient()
+	if err != nil {
+		return nil, err
+	}
+
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(dc)
+	return restmapper.NewShortcutExpander(mapper, dc, nil), nil
+}
+
+func (rcg *restConfigGetter) ToRawKubeConfigLoader() clientcmd.ClientConfig {
+	return &restClientConfig{config: rcg.config, namespace: rcg.namespace}
+}
+
+type restClientConfig struct {
+	config    *rest.Config
+	namespace string
+}
+
+func (rcc *restClientConfig) RawConfig() (clientcmdapi.Config, error) {
+	return clientcmdapi.Config{}, nil
+}
 
-	// Drop their rest.Config and just return inject own
-	wrapper := func(*rest.Config) *rest.Config {
-		return config
+func (rcc *restClientConfig) ClientConfig() (*rest.Config, error) {
+	return rest.CopyConfig(rcc.config), nil
+}
+
+func (rcc *restClientConfig) Namespace() (string, bool, error) {
+	if rcc.namespace == "" {
+		return metav1.NamespaceDefault, false, nil
 	}
-	cliConfig.WithWrapConfigFn(wrapper)
-	if err := actionConfig.Init(cliConfig, namespace, "secret", log.Debugf); err != nil {
+	return rcc.namespace, true, nil
+}
+
+func (rcc *restClientConfig) ConfigAccess() clientcmd.ConfigAccess {
+	return clientcmd.NewDefaultClientConfigLoadingRules()
+}
+
+func GetActionConfig(namespace string, config *rest.Config, debugLog action.DebugLog) (*action.Configuration, error) {
+	actionConfig := new(action.Configuration)
+	getter := &restConfigGetter{config: config, namespace: namespace}
+
+	if err := actionConfig.Init(getter, namespace, "secret", debugLog); err != nil {
 		return nil, err
 	}
 	return actionConfig, nil
@@ -86,10 +150,39 @@ type Helm struct {
 	ChartVersion     string
 	ReleaseVersion   int
 	DryRun           bool
+	Wait             bool
+	Atomic           bool
+	Timeout          time.Duration
+	// Context and Log are set when the action runs as a helm job.
+	Context context.Context
+	Log     action.DebugLog
+}
+
+func (h *Helm) context() context.Context {
+	if h.Context == nil {
+		return context.Background()
+	}
+	return h.Context
+}
+
+func (h *Helm) debugLog() action.DebugLog {
+	if h.Log == nil {
+		return log.Debugf
+	}
+	return h.Log
+}
+
+const defaultHelmTimeout = 300 * time.Second
+
+func helmTimeout(opts HelmOptions) time.Duration {
+	if opts.TimeoutSeconds <= 0 {
+		return defaultHelmTimeout
+	}
+	return time.Duration(opts.TimeoutSeconds) * time.Second
 }
 
 func (h *Helm) ShowChartValues(config *rest.Config, opts HelmOptions) (vals string, err error) {
-	h.ActionConfig, err = GetActionConfig(context.Background(), h.ReleaseNamespace, config)
+	h.ActionConfig, err = GetActionConfig(h.ReleaseNamespace, config, h.debugLog())
 	if err != nil {
 		return "", err
 	}
@@ -100,16 +193,16 @@ func (h *Helm) ShowChartValues(config *rest.Config, opts HelmOptions) (vals stri
 
 	chartRef := opts.ChartName
 	if opts.IsOCI {
-		rc, errReg := newRegistryClient(h.EnvSettings, true)
+		rc, errReg := newRegistryClient(h.EnvSettings, registryPlainHTTP(opts.RepoURL), opts.Registry.InsecureSkipTLSVerify)
 		if errReg != nil {
 			return "", fmt.Errorf("failed to create registry: %w", errReg)
 		}
 		sc.SetRegistryClient(rc)
-		chartRef = opts.RepoURL
+		chartRef = ociReference(opts.RepoURL)
 		sc.ChartPathOptions.RepoURL = ""
 	}
 
-	cp, err := sc.ChartPathOptions.LocateChart(chartRef, h.EnvSettings)
+	cp, err := h.locateChart(&sc.ChartPathOptions, chartRef, opts)
 	if err != nil {
 		return "", fmt.Errorf("failed to locate chart: %w", err)
 	}
@@ -118,7 +211,7 @@ func (h *Helm) ShowChartValues(config *rest.Config, opts HelmOptions) (vals stri
 }
 
 func (h *Helm) ListReleases(config *rest.Config) (rel []*release.Release, err error) {
-	h.ActionConfig, err = GetActionConfig(context.Background(), h.ReleaseNamespace, config)
+	h.ActionConfig, err = GetActionConfig(h.ReleaseNamespace, config, h.debugLog())
 	if err != nil {
 		return nil, err
 	}
@@ -132,7 +225,7 @@ func (h *Helm) ListReleases(config *rest.Config) (rel []*release.Release, err er
 }
 
 func (h *Helm) GetRelease(config *rest.Config) (rel *release.Release, err error) {
-	h.ActionConfig, err = GetActionConfig(context.Background(), h.ReleaseNamespace, config)
+	h.ActionConfig, err = GetActionConfig(h.ReleaseNamespace, config, h.debugLog())
 	if err != nil {
 		return nil, err
 	}
@@ -143,7 +236,102 @@ func (h *Helm) GetRelease(config *rest.Config) (rel *release.Release, err error)
 	return gr.Run(h.ReleaseName)
 }
 
-func newRegistryClient(settings *cli.EnvSettings, plainHTTP bool) (*registry.Client, error) {
+func (h *Helm) ReleaseHistory(config *rest.Config) (rel []*release.Release, err error) {
+	h.ActionConfig, err = GetActionConfig(h.ReleaseNamespace, config, h.debugLog())
+	if err != nil {
+		return nil, err
+	}
+
+	hc := action.NewHistory(h.ActionConfig)
+	hc.Max = h.Limit
+
+	return hc.Run(h.ReleaseName)
+}
+
+func (h *Helm) RollbackRelease(config *rest.Config) (err error) {
+	h.ActionConfig, err = GetActionConfig(h.ReleaseNamespace, config, h.debugLog())
+	if err != nil {
+		return err
+	}
+
+	rb := action.NewRollback(h.ActionConfig)
+	rb.Version = h.ReleaseVersion
+	rb.DryRun = h.DryRun
+	rb.Wait = h.Wait
+	rb.Timeout = h.Timeout
+
+	return rb.Run(h.ReleaseName)
+}
+
+// locateChart returns the path of the chart, charts from the chart cache are
+// used as they are.
+func (h *Helm) locateChart(cpo *action.ChartPathOptions, chartRef string, opts HelmOptions) (string, error) {
+	if opts.LocalChart != "" {
+		return localChartPath(opts.LocalChart)
+	}
+	return cpo.LocateChart(chartRef, h.EnvSettings)
+}
+
+// updateDependencies downloads the dependencies of the chart into its charts
+// directory and returns the reloaded chart. Archives, like the charts of the
+// chart cache, are expanded into a temporary directory first.
+func (h *Helm) updateDependencies(chartPath, keyring string, insecureSkipTLSVerify bool, out io.Writer) (*chart.Chart, error) {
+	fi, err := os.Stat(chartPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		tmpDir, err := os.MkdirTemp("", "lutho-chart-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmpDir)
+
+		if err := chartutil.ExpandFile(tmpDir, chartPath); err != nil {
+			return nil, fmt.Errorf("failed to expand chart archive: %w", err)
+		}
+
+		entries, err := os.ReadDir(tmpDir)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) != 1 || !entries[0].IsDir() {
+			return nil, fmt.Errorf("unexpected content of chart archive '%s'", filepath.Base(chartPath))
+		}
+		chartPath = filepath.Join(tmpDir, entries[0].Name())
+	}
+
+	rc, err := newRegistryClient(h.EnvSettings, false, insecureSkipTLSVerify)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create registry: %w", err)
+	}
+
+	man := &downloader.Manager{
+		Out:              out,
+		ChartPath:        chartPath,
+		Keyring:          keyring,
+		SkipUpdate:       false,
+		Getters:          getter.All(h.EnvSettings),
+		RegistryClient:   rc,
+		RepositoryConfig: h.EnvSettings.RepositoryConfig,
+		RepositoryCache:  h.EnvSettings.RepositoryCache,
+		Debug:            h.EnvSettings.Debug,
+	}
+	if err := man.Update(); err != nil {
+		return nil, fmt.Errorf("failed to update chart dependencies: %w", err)
+	}
+
+	// Reload the chart with the updated Chart.lock file.
+	chrt, err := loader.Load(chartPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reload chart after repo update: %w", err)
+	}
+
+	return chrt, nil
+}
+
+func newRegistryClient(settings *cli.EnvSettings, plainHTTP, insecureSkipTLSVerify bool) (*registry.Client, error) {
 	opts := []registry.ClientOption{
 		registry.ClientOptDebug(settings.Debug),
 		registry.ClientOptEnableCache(true),
@@ -153,6 +341,12 @@ func newRegistryClient(settings *cli.EnvSettings, plainHTTP bool) (*registry.Cli
 	if plainHTTP {
 		opts = append(opts, registry.ClientOptPlainHTTP())
 	}
+	if insecureSkipTLSVerify {
+		opts = append(opts, registry.ClientOptHTTPClient(&http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}}))
+	}
 
 	// Create a new registry client
 	registryClient, err := registry.NewClient(opts...)
@@ -163,7 +357,7 @@ func newRegistryClient(settings *cli.EnvSettings, plainHTTP bool) (*registry.Cli
 }
 
 func (h *Helm) InstallRelease(config *rest.Config, opts HelmOptions, values map[string]interface{}) (rel *release.Release, err error) {
-	h.ActionConfig, err = GetActionConfig(context.Background(), h.ReleaseNamespace, config)
+	h.ActionConfig, err = GetActionConfig(h.ReleaseNamespace, config, h.debugLog())
 	if err != nil {
 		return nil, err
 	}
@@ -173,20 +367,28 @@ func (h *Helm) InstallRelease(config *rest.Config, opts HelmOptions, values map[
 	i.ReleaseName = h.ReleaseName
 	i.Namespace = h.ReleaseNamespace
 	i.DryRun = h.DryRun
+	i.Wait = h.Wait
+	i.Atomic = h.Atomic
+	i.Timeout = h.Timeout
+	i.DependencyUpdate = opts.DependencyUpdate
 	i.ChartPathOptions.RepoURL = opts.RepoURL
 
+	return h.runInstall(i, opts, values)
+}
+
+func (h *Helm) runInstall(i *action.Install, opts HelmOptions, values map[string]interface{}) (*release.Release, error) {
 	chartRef := opts.ChartName
 	if opts.IsOCI {
-		rc, errReg := newRegistryClient(h.EnvSettings, true)
+		rc, errReg := newRegistryClient(h.EnvSettings, registryPlainHTTP(opts.RepoURL), opts.Registry.InsecureSkipTLSVerify)
 		if errReg != nil {
 			return nil, fmt.Errorf("failed to create registry: %w", errReg)
 		}
 		i.SetRegistryClient(rc)
-		chartRef = opts.RepoURL
+		chartRef = ociReference(opts.RepoURL)
 		i.ChartPathOptions.RepoURL = ""
 	}
 
-	cp, err := i.ChartPathOptions.LocateChart(chartRef, h.EnvSettings)
+	cp, err := h.locateChart(&i.ChartPathOptions, chartRef, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to locate chart: %w", err)
 	}
@@ -196,8 +398,6 @@ func (h *Helm) InstallRelease(config *rest.Config, opts HelmOptions, values map[
 		return nil, fmt.Errorf("failed to load chart: %w", err)
 	}
 
-	providers := getter.All(h.EnvSettings)
-
 	if dep := chart.Metadata.Dependencies; dep != nil {
 		if err := action.CheckDependencies(chart, dep); err != nil {
 			err = fmt.Errorf("failed to check chart dependencies: %w", err)
@@ -205,31 +405,17 @@ func (h *Helm) InstallRelease(config *rest.Config, opts HelmOptions, values map[
 				return nil, err
 			}
 
-			man := &downloader.Manager{
-				Out:              os.Stdout,
-				ChartPath:        cp,
-				Keyring:          i.ChartPathOptions.Keyring,
-				SkipUpdate:       false,
-				Getters:          providers,
-				RepositoryConfig: h.EnvSettings.RepositoryConfig,
-				RepositoryCache:  h.EnvSettings.RepositoryCache,
-				Debug:            h.EnvSettings.Debug,
-			}
-			if err := man.Update(); err != nil {
+			if chart, err = h.updateDependencies(cp, i.ChartPathOptions.Keyring, opts.Registry.InsecureSkipTLSVerify, os.Stdout); err != nil {
 				return nil, err
 			}
-			// Reload the chart with the updated Chart.lock file.
-			if chart, err = loader.Load(cp); err != nil {
-				return nil, fmt.Errorf("failed to reload chart after repo update: %w", err)
-			}
 		}
 	}
 
-	return i.RunWithContext(context.TODO(), chart, values)
+	return i.RunWithContext(h.context(), chart, values)
 }
 
 func (h *Helm) UpgradeRelease(config *rest.Config, opts HelmOptions, values map[string]interface{}) (rel *release.Release, err error) {
-	h.ActionConfig, err = GetActionConfig(context.Background(), h.ReleaseNamespace, config)
+	h.ActionConfig, err = GetActionConfig(h.ReleaseNamespace, config, h.debugLog())
 	if err != nil {
 		return nil, err
 	}
@@ -239,20 +425,24 @@ func (h *Helm) UpgradeRelease(config *rest.Config, opts HelmOptions, values map[
 	u.Namespace = h.ReleaseNamespace
 	u.DryRun = h.DryRun
 	u.ReuseValues = opts.ReuseValues
+	u.Wait = h.Wait
+	u.Atomic = h.Atomic
+	u.Timeout = h.Timeout
+	u.DependencyUpdate = opts.DependencyUpdate
 	u.ChartPathOptions.RepoURL = opts.RepoURL
 
 	chartRef := opts.ChartName
 	if opts.IsOCI {
-		rc, errReg := newRegistryClient(h.EnvSettings, true)
+		rc, errReg := newRegistryClient(h.EnvSettings, registryPlainHTTP(opts.RepoURL), opts.Registry.InsecureSkipTLSVerify)
 		if errReg != nil {
 			return nil, fmt.Errorf("failed to create registry: %w", errReg)
 		}
 		u.SetRegistryClient(rc)
-		chartRef = opts.RepoURL
+		chartRef = ociReference(opts.RepoURL)
 		u.ChartPathOptions.RepoURL = ""
 	}
 
-	cp, err := u.ChartPathOptions.LocateChart(chartRef, h.EnvSettings)
+	cp, err := h.locateChart(&u.ChartPathOptions, chartRef, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to locate chart: %w", err)
 	}
@@ -262,8 +452,6 @@ func (h *Helm) UpgradeRelease(config *rest.Config, opts HelmOptions, values map[
 		return nil, fmt.Errorf("failed to load chart: %w", err)
 	}
 
-	providers := getter.All(h.EnvSettings)
-
 	if dep := chart.Metadata.Dependencies; dep != nil {
 		if err := action.CheckDependencies(chart, dep); err != nil {
 			err = fmt.Errorf("failed to check chart dependencies: %w", err)
@@ -271,31 +459,17 @@ func (h *Helm) UpgradeRelease(config *rest.Config, opts HelmOptions, values map[
 				return nil, err
 			}
 
-			man := &downloader.Manager{
-				Out:              os.Stdout,
-				ChartPath:        cp,
-				Keyring:          u.ChartPathOptions.Keyring,
-				SkipUpdate:       false,
-				Getters:          providers,
-				RepositoryConfig: h.EnvSettings.RepositoryConfig,
-				RepositoryCache:  h.EnvSettings.RepositoryCache,
-				Debug:            h.EnvSettings.Debug,
-			}
-			if err := man.Update(); err != nil {
+			if chart, err = h.updateDependencies(cp, u.ChartPathOptions.Keyring, opts.Registry.InsecureSkipTLSVerify, os.Stdout); err != nil {
 				return nil, err
 			}
-			// Reload the chart with the updated Chart.lock file.
-			if chart, err = loader.Load(cp); err != nil {
-				return nil, fmt.Errorf("failed to reload chart after repo update: %w", err)
-			}
 		}
 	}
 
-	return u.RunWithContext(context.TODO(), h.ReleaseName, chart, values)
+	return u.RunWithContext(h.context(), h.ReleaseName, chart, values)
 }
 
 func (h *Helm) UninstallRelease(config *rest.Config) (urr *release.UninstallReleaseResponse, err error) {
-	h.ActionConfig, err = GetActionConfig(context.Background(), h.ReleaseNamespace, config)
+	h.ActionConfig, err = GetActionConfig(h.ReleaseNamespace, config, h.debugLog())
 	if err != nil {
 		return nil, err
 	}
@@ -306,42 +480,79 @@ func (h *Helm) UninstallRelease(config *rest.Config) (urr *release.UninstallRele
 	return u.Run(h.ReleaseName)
 }
 
-func runPull(h *Helm, config *rest.Config) (result string, err error) {
-	h.ActionConfig, err = GetActionConfig(context.Background(), h.ReleaseNamespace, config)
+func runPull(h *Helm, config *rest.Config, opts HelmOptions, destDir string) (result string, err error) {
+	h.ActionConfig, err = GetActionConfig(h.ReleaseNamespace, config, h.debugLog())
 	if err != nil {
 		return "", err
 	}
 
-	registryClient, err := newRegistryClient(h.EnvSettings, false)
+	registryClient, err := newRegistryClient(h.EnvSettings, opts.IsOCI && registryPlainHTTP(h.RepoURL), opts.Registry.InsecureSkipTLSVerify)
 	if err != nil {
 		return "", fmt.Errorf("failed to created registry client: %w", err)
 	}
 	h.ActionConfig.RegistryClient = registryClient
 
 	pullClient := action.NewPullWithOpts(action.WithConfig(h.ActionConfig))
-	pullClient.DestDir = h.EnvSettings.RepositoryCache
+	pullClient.DestDir = destDir
 	pullClient.Settings = h.EnvSettings
 	pullClient.Version = h.ChartVersion
 
-	return pullClient.Run(h.RepoURL)
+	chartRef := h.RepoURL
+	switch {
+	case opts.IsOCI:
+		chartRef = ociReference(h.RepoURL)
+	case chartRef == "":
+		chartRef = h.ChartName
+	case h.ChartName != "":
+		pullClient.RepoURL = h.RepoURL
+		chartRef = h.ChartName
+	}
+
+	return pullClient.Run(chartRef)
 }
 
-func (h *Helm) PullChart(opts HelmOptions, config *rest.Config) (repo string, err error) {
-	if opts.EnvPath == "" {
-		opts.EnvPath = "/.lutho/helm"
+// PullChart downloads the chart into the chart cache.
+func (h *Helm) PullChart(opts HelmOptions, config *rest.Config) (ci ChartInfo, err error) {
+	// A custom env path gets its own copy of the settings, so the paths don't
+	// leak into the cluster's settings used by the repository ops.
+	if opts.EnvPath != "" {
+		settings := *h.EnvSettings
+		h.EnvSettings = &settings
+		h.EnvSettings.RegistryConfig = homeDir + opts.EnvPath + "/registry/config.json"
+		h.EnvSettings.RepositoryConfig = homeDir + opts.EnvPath + "/repositories.yaml"
+		h.EnvSettings.RepositoryCache = homeDir + opts.EnvPath + "/repository"
 	}
 
-	h.EnvSettings.RegistryConfig = homeDir + opts.EnvPath + "/registry/config.json"
-	h.EnvSettings.RepositoryConfig = homeDir + opts.EnvPath + "/repositories.yaml"
-	h.EnvSettings.RepositoryCache = homeDir + opts.EnvPath + "/repository"
+	if err := os.MkdirAll(chartCacheDir(), os.ModePerm); err != nil {
+		return ci, err
+	}
 
-	result, err := runPull(h, config)
+	// Pull into a scratch dir first, the archive name is only known once the
+	// chart version has been resolved.
+	destDir, err := os.MkdirTemp(chartCacheDir(), ".pull-")
 	if err != nil {
-		return "", err
+		return ci, err
+	}
+	defer os.RemoveAll(destDir)
+
+	if _, err := runPull(h, config, opts, destDir); err != nil {
+		return ci, err
+	}
+
+	archives, err := filepath.Glob(filepath.Join(destDir, "*.tgz"))
+	if err != nil {
+		return ci, err
+	}
+	if len(archives) != 1 {
+		return ci, fmt.Errorf("expected one chart archive to be pulled, got %d", len(archives))
+	}
+
+	ref := filepath.Base(archives[0])
+	if err := os.Rename(archives[0], filepath.Join(chartCacheDir(), ref)); err != nil {
+		return ci, err
 	}
-	fmt.Println("result helmPull ->", result)
 
-	return "", nil
+	return loadCachedChart(ref)
 }
 
 type HelmChartTags struct {
@@ -351,7 +562,7 @@ type HelmChartTags struct {
 
 func (h *Helm) GetChartTags(opts HelmOptions) (ct HelmChartTags, err error) {
 	if opts.IsOCI {
-		tags, err := h.GetTags()
+		tags, err := h.GetTags(opts.Registry.InsecureSkipTLSVerify)
 		if err != nil {
 			return ct, err
 		}
@@ -359,7 +570,16 @@ func (h *Helm) GetChartTags(opts HelmOptions) (ct HelmChartTags, err error) {
 		ct.ChartName = opts.ChartName
 		ct.Tags = tags
 	} else {
-		f, err := h.GetIndexFile()
+		entry := &repo.Entry{Name: h.ChartName, URL: h.RepoURL}
+		if repoChartRef(opts) {
+			e, chartName, err := h.repoEntry(opts.ChartName)
+			if err != nil {
+				return ct, err
+			}
+			entry, h.ChartName = e, chartName
+		}
+
+		f, err := h.GetIndexFile(entry)
 		if err != nil {
 			return ct, err
 		}
@@ -376,31 +596,15 @@ func (h *Helm) GetChartTags(opts HelmOptions) (ct HelmChartTags, err error) {
 	return ct, nil
 }
 
-type Entry struct {
-	Version string `yaml:"version"`
-	// Created time.Time
-}
-
-type Entries []Entry
-
-type Index struct {
-	Entries map[string]Entries
-}
-
-func (h *Helm) GetIndexFile() (*Index, error) {
-	cr, crErr := repo.NewChartRepository(&repo.Entry{
-		InsecureSkipTLSverify: false,
-		PassCredentialsAll:    false,
-		Name:                  h.ChartName,
-		URL:                   h.RepoURL,
-	}, getter.All(h.EnvSettings))
+func (h *Helm) GetIndexFile(entry *repo.Entry) (*repo.IndexFile, error) {
+	cr, crErr := repo.NewChartRepository(entry, getter.All(h.EnvSettings))
 	if crErr != nil {
 		return nil, fmt.Errorf("failed to initialize index file repository: %w", crErr)
 	}
 
 	cr.CachePath = h.EnvSettings.RepositoryCache
 
-	indexURL, err := repo.ResolveReferenceURL(h.RepoURL, "index.yaml")
+	indexURL, err := repo.ResolveReferenceURL(entry.URL, "index.yaml")
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve index file URL: %w", err)
 	}
@@ -421,17 +625,18 @@ func (h *Helm) GetIndexFile() (*Index, error) {
 		return nil, fmt.Errorf("failed to read index file: %w", err)
 	}
 
-	index := &Index{}
+	index := &repo.IndexFile{}
 	errUnmarshal := yaml.Unmarshal(idxF, index)
 	if errUnmarshal != nil {
 		return nil, errUnmarshal
 	}
+	index.SortEntries()
 
 	return index, nil
 }
 
-func (h *Helm) GetTags() (tags []string, err error) {
-	tagsURL, err := url.Parse(h.RepoURL)
+func (h *Helm) GetTags(insecureSkipTLSVerify bool) (tags []string, err error) {
+	tagsURL, err := url.Parse(ociReference(h.RepoURL))
 	if err != nil {
 		return nil, err
 	}
@@ -443,20 +648,22 @@ func (h *Helm) GetTags() (tags []string, err error) {
 
 	client := &http.Client{Transport: &http.Transport{
 		Proxy:             http.ProxyFromEnvironment,
-		TLSClientConfig:   &tls.Config{},
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: insecureSkipTLSVerify},
 		DisableKeepAlives: true,
 	}}
 
+	credStore, err := credentials.NewStore(h.EnvSettings.RegistryConfig, credentials.StoreOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load registry credentials: %w", err)
+	}
+
 	remoteRepo.Client = &auth.Client{
-		Client: client,
-		Cache:  nil,
-		Credential: auth.StaticCredential(tagsURL.Host, auth.Credential{
-			Username: "",
-			Password: "",
-		}),
+		Client:     client,
+		Cache:      auth.NewCache(),
+		Credential: credentials.Credential(credStore),
 	}
 
-	remoteRepo.PlainHTTP = tagsURL.Scheme == "http"
+	remoteRepo.PlainHTTP = registryPlainHTTP(h.RepoURL)
 
 	errTags := remoteRepo.Tags(context.Background(), "", func(tagsResult []string) error {
 		for _, tag := range tagsResult {
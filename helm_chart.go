@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+const (
+	defaultHelmCharts  = "/.lutho/helm/charts"
+	maxChartUploadSize = 64 << 20
+)
+
+var errInvalidChartRef = errors.New("invalid chart reference")
+
+type ChartFile struct {
+	Path string `json:"path"`
+	Size int    `json:"size"`
+}
+
+// ChartInfo describes a chart archive of the chart cache, Ref is the archive
+// name used to download it or to install from it.
+type ChartInfo struct {
+	Ref      string          `json:"ref"`
+	Metadata *chart.Metadata `json:"metadata"`
+	Files    []ChartFile     `json:"files"`
+	Readme   string          `json:"readme"`
+}
+
+func chartCacheDir() string {
+	return filepath.Join(homeDir, defaultHelmCharts)
+}
+
+// localChartPath resolves a chart cache reference to the archive path. Only
+// plain archive names are accepted so the reference can't leave the cache.
+func localChartPath(ref string) (string, error) {
+	if ref == "" || ref != filepath.Base(ref) || !strings.HasSuffix(ref, ".tgz") {
+		return "", fmt.Errorf("%w '%s'", errInvalidChartRef, ref)
+	}
+
+	chartPath := filepath.Join(chartCacheDir(), ref)
+	if _, err := os.Stat(chartPath); err != nil {
+		return "", fmt.Errorf("chart '%s' is not in the chart cache: %w", ref, err)
+	}
+
+	return chartPath, nil
+}
+
+func newChartInfo(ref string, chrt *chart.Chart) ChartInfo {
+	ci := ChartInfo{Ref: ref, Metadata: chrt.Metadata, Files: []ChartFile{}}
+
+	for _, f := range chrt.Raw {
+		ci.Files = append(ci.Files, ChartFile{Path: f.Name, Size: len(f.Data)})
+		if ci.Readme == "" && !strings.Contains(f.Name, "/") &&
+			strings.HasPrefix(strings.ToLower(f.Name), "readme") {
+			ci.Readme = string(f.Data)
+		}
+	}
+	sort.Slice(ci.Files, func(i, j int) bool { return ci.Files[i].Path < ci.Files[j].Path })
+
+	return ci
+}
+
+func loadCachedChart(ref string) (ChartInfo, error) {
+	chartPath, err := localChartPath(ref)
+	if err != nil {
+		return ChartInfo{}, err
+	}
+
+	chrt, err := loader.Load(chartPath)
+	if err != nil {
+		return ChartInfo{}, fmt.Errorf("failed to load chart: %w", err)
+	}
+
+	return newChartInfo(ref, chrt), nil
+}
+
+// storeChart packages the chart into the chart cache as <name>-<version>.tgz.
+func storeChart(chrt *chart.Chart) (ChartInfo, error) {
+	if err := chrt.Validate(); err != nil {
+		return ChartInfo{}, fmt.Errorf("invalid chart: %w", err)
+	}
+
+	if err := os.MkdirAll(chartCacheDir(), os.ModePerm); err != nil {
+		return ChartInfo{}, err
+	}
+
+	chartPath, err := chartutil.Save(chrt, chartCacheDir())
+	if err != nil {
+		return ChartInfo{}, fmt.Errorf("failed to store chart: %w", err)
+	}
+
+	return loadCachedChart(filepath.Base(chartPath))
+}
+
+func storeChartArchive(r io.Reader) (ChartInfo, error) {
+	chrt, err := loader.LoadArchive(r)
+	if err != nil {
+		return ChartInfo{}, fmt.Errorf("failed to load chart archive: %w", err)
+	}
+
+	return storeChart(chrt)
+}
+
+// storeChartFiles stores a chart uploaded as the files of its directory. The
+// chart directory itself is stripped when every path starts with it.
+func storeChartFiles(files []*loader.BufferedFile) (ChartInfo, error) {
+	if len(files) == 0 {
+		return ChartInfo{}, errors.New("no chart files uploaded")
+	}
+
+	for _, f := range files {
+		f.Name = path.Clean(filepath.ToSlash(f.Name))
+		if f.Name == "." || path.IsAbs(f.Name) || strings.HasPrefix(f.Name, "../") {
+			return ChartInfo{}, fmt.Errorf("invalid chart file path '%s'", f.Name)
+		}
+	}
+
+	root, _, found := strings.Cut(files[0].Name, "/")
+	for _, f := range files {
+		if !found || !strings.HasPrefix(f.Name, root+"/") {
+			found = false
+			break
+		}
+	}
+	if found {
+		for _, f := range files {
+			f.Name = strings.TrimPrefix(f.Name, root+"/")
+		}
+	}
+
+	chrt, err := loader.LoadFiles(files)
+	if err != nil {
+		return ChartInfo{}, fmt.Errorf("failed to load chart files: %w", err)
+	}
+
+	return storeChart(chrt)
+}
+
+type ChartDependencies struct {
+	Chart ChartInfo   `json:"chart"`
+	Lock  *chart.Lock `json:"lock"`
+	Log   string      `json:"log"`
+}
+
+// UpdateChartDependencies downloads the dependencies of a chart of the chart
+// cache and stores the chart with its charts directory and Chart.lock again.
+func (h *Helm) UpdateChartDependencies(opts HelmOptions) (ChartDependencies, error) {
+	chartPath, err := localChartPath(opts.LocalChart)
+	if err != nil {
+		return ChartDependencies{}, err
+	}
+
+	var out bytes.Buffer
+	chrt, err := h.updateDependencies(chartPath, "", opts.Registry.InsecureSkipTLSVerify, &out)
+	if err != nil {
+		return ChartDependencies{Log: out.String()}, err
+	}
+
+	ci, err := storeChart(chrt)
+	if err != nil {
+		return ChartDependencies{Log: out.String()}, err
+	}
+
+	return ChartDependencies{Chart: ci, Lock: chrt.Lock, Log: out.String()}, nil
+}
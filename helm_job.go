@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	helmJobRetention  = time.Hour
+	maxHelmJobEvents  = 1000
+	helmJobEventsTrim = 100
+)
+
+var errHelmJobNotFound = errors.New("helm job not found")
+
+var HelmJobStatus = struct {
+	running,
+	succeeded,
+	failed,
+	cancelled string
+}{
+	running:   "running",
+	succeeded: "succeeded",
+	failed:    "failed",
+	cancelled: "cancelled",
+}
+
+var HelmJobPhase = struct {
+	started,
+	rendering,
+	hooks,
+	resources,
+	waiting,
+	log,
+	testLog,
+	finished string
+}{
+	started:   "started",
+	rendering: "rendering",
+	hooks:     "hooks",
+	resources: "resources",
+	waiting:   "waiting",
+	log:       "log",
+	testLog:   "testLog",
+	finished:  "finished",
+}
+
+type HelmJobEvent struct {
+	Time    time.Time `json:"time"`
+	Phase   string    `json:"phase"`
+	Message string    `json:"message"`
+}
+
+type HelmJobState struct {
+	ID          string         `json:"id"`
+	OpID        string         `json:"opID"`
+	Type        string         `json:"type"`
+	Release     string         `json:"release"`
+	Namespace   string         `json:"namespace"`
+	Status      string         `json:"status"`
+	Cancellable bool           `json:"cancellable"`
+	Events      []HelmJobEvent `json:"events"`
+	Result      string         `json:"result"`
+	Error       string         `json:"error"`
+	Started     time.Time      `json:"started"`
+	Finished    time.Time      `json:"finished"`
+}
+
+// helmJobOwner is the browser session and proxy user that started a job.
+// It outlives a re-auth of the session, so the session keeps its jobs.
+type helmJobOwner struct {
+	session string
+	user    string
+	groups  string
+}
+
+func newHelmJobOwner(sessionID string, identity *rest.ImpersonationConfig) helmJobOwner {
+	owner := helmJobOwner{session: sessionID}
+	if identity != nil {
+		owner.user = identity.UserName
+		owner.groups = strings.Join(identity.Groups, ",")
+	}
+	return owner
+}
+
+// HelmJob is a long-running helm op running in the background. Jobs outlive
+// the data session that started them, a reconnected session attaches to the
+// job to receive its remaining events.
+type HelmJob struct {
+	state  HelmJobState
+	owner  helmJobOwner
+	ctx    context.Context
+	cancel context.CancelFunc
+	dss    DataStreamSession
+	lock   sync.Mutex
+}
+
+func (job *HelmJob) State() HelmJobState {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+
+	state := job.state
+	state.Events = append([]HelmJobEvent{}, job.state.Events...)
+	return state
+}
+
+func (job *HelmJob) Attach(dss DataStreamSession) {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	job.dss = dss
+}
+
+func (job *HelmJob) send(dsm DataStreamMessage) {
+	job.lock.Lock()
+	dss := job.dss
+	job.lock.Unlock()
+
+	// The session may be gone, the client catches up with helmJobStatus.
+	_ = dss.WriteJSON(dsm)
+}
+
+func (job *HelmJob) Emit(phase, message string) {
+	event := HelmJobEvent{Time: time.Now(), Phase: phase, Message: message}
+
+	job.lock.Lock()
+	if len(job.state.Events) >= maxHelmJobEvents {
+		job.state.Events = job.state.Events[helmJobEventsTrim:]
+	}
+	job.state.Events = append(job.state.Events, event)
+	opID, jobID := job.state.OpID, job.state.ID
+	job.lock.Unlock()
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	job.send(DataStreamMessage{
+		Op:   DataStreamOp{OpID: opID, Type: WSOpType.helmJobEvent, JobID: jobID},
+		Data: string(data),
+	})
+}
+
+// helmLogPhase maps the log lines of helm actions and its kube client to the
+// phase of the job they belong to.
+func helmLogPhase(message string) string {
+	msg := strings.ToLower(message)
+	switch {
+	case strings.Contains(msg, "hook") || strings.Contains(msg, "watching for changes") ||
+		strings.Contains(msg, "jobs active") || strings.HasPrefix(msg, "pod "):
+		return HelmJobPhase.hooks
+	case strings.Contains(msg, "wait") || strings.Contains(msg, "ready"):
+		return HelmJobPhase.waiting
+	case strings.Contains(msg, "creating") || strings.Contains(msg, "created a new") ||
+		strings.Contains(msg, "checking") || strings.HasPrefix(msg, "patch ") ||
+		strings.Contains(msg, "deleting") || strings.Contains(msg, "delete for"):
+		return HelmJobPhase.resources
+	}
+	return HelmJobPhase.log
+}
+
+// Log is handed to helm as its debug log and turns every line into an event.
+func (job *HelmJob) Log(format string, v ...interface{}) {
+	log.Debugf(format, v...)
+	message := strings.TrimSpace(fmt.Sprintf(format, v...))
+	job.Emit(helmLogPhase(message), message)
+}
+
+func (job *HelmJob) Cancel() error {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+
+	if !job.state.Cancellable {
+		return fmt.Errorf("helm job '%s' of type '%s' can't be cancelled", job.state.ID, job.state.Type)
+	}
+	if job.state.Status != HelmJobStatus.running {
+		return fmt.Errorf("helm job '%s' is already %s", job.state.ID, job.state.Status)
+	}
+	job.cancel()
+	return nil
+}
+
+// Finish records the result and sends it with the type of the op that
+// started the job, like the reply of a synchronous op.
+func (job *HelmJob) Finish(data []byte, err error) {
+	status := HelmJobStatus.succeeded
+	switch {
+	case err != nil && job.ctx.Err() != nil:
+		status = HelmJobStatus.cancelled
+	case err != nil:
+		status = HelmJobStatus.failed
+	}
+	job.cancel()
+
+	// The state is final before the finished event, clients fetching the job
+	// on that event never see it running.
+	job.lock.Lock()
+	job.state.Status = status
+	job.state.Result = string(data)
+	if err != nil {
+		job.state.Error = err.Error()
+	}
+	job.state.Finished = time.Now()
+	dsm := DataStreamMessage{
+		Op:    DataStreamOp{OpID: job.state.OpID, Type: job.state.Type, JobID: job.state.ID},
+		Data:  job.state.Result,
+		Error: job.state.Error,
+	}
+	job.lock.Unlock()
+
+	job.Emit(HelmJobPhase.finished, status)
+	job.send(dsm)
+}
+
+type HelmJobStore struct {
+	Jobs map[string]*HelmJob
+	Lock sync.Mutex
+}
+
+var helmJobs = HelmJobStore{Jobs: make(map[string]*HelmJob)}
+
+func (hjs *HelmJobStore) prune() {
+	for id, job := range hjs.Jobs {
+		state := job.State()
+		if state.Status != HelmJobStatus.running && time.Since(state.Finished) > helmJobRetention {
+			delete(hjs.Jobs, id)
+		}
+	}
+}
+
+func (hjs *HelmJobStore) Start(dss DataStreamSession, recvMsg DataStreamMessage, cancellable bool) (*HelmJob, error) {
+	id, err := genSessionId()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &HelmJob{
+		state: HelmJobState{
+			ID:          id,
+			OpID:        recvMsg.Op.OpID,
+			Type:        recvMsg.Op.Type,
+			Release:     recvMsg.Op.Request.Name,
+			Namespace:   recvMsg.Op.Request.Namespace,
+			Status:      HelmJobStatus.running,
+			Cancellable: cancellable,
+			Events:      []HelmJobEvent{},
+			Started:     time.Now(),
+		},
+		owner:  dss.owner,
+		ctx:    ctx,
+		cancel: cancel,
+		dss:    dss,
+	}
+
+	hjs.Lock.Lock()
+	hjs.prune()
+	hjs.Jobs[id] = job
+	hjs.Lock.Unlock()
+
+	job.Emit(HelmJobPhase.started, recvMsg.Op.Type)
+
+	return job, nil
+}
+
+// Get only returns jobs started by the same browser session and proxy user,
+// so other sessions can't see them.
+func (hjs *HelmJobStore) Get(id string, owner helmJobOwner) (*HelmJob, error) {
+	hjs.Lock.Lock()
+	defer hjs.Lock.Unlock()
+
+	job, ok := hjs.Jobs[id]
+	if !ok || job.owner != owner {
+		return nil, fmt.Errorf("%w: '%s'", errHelmJobNotFound, id)
+	}
+	return job, nil
+}
+
+func (hjs *HelmJobStore) List(owner helmJobOwner) []HelmJobState {
+	hjs.Lock.Lock()
+	defer hjs.Lock.Unlock()
+	hjs.prune()
+
+	jobs := []HelmJobState{}
+	for _, job := range hjs.Jobs {
+		if job.owner == owner {
+			jobs = append(jobs, job.State())
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Started.Before(jobs[j].Started) })
+
+	return jobs
+}
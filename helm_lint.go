@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/lint/support"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+type HelmLintOptions struct {
+	// Strict fails the lint on warnings too.
+	Strict bool `json:"strict"`
+}
+
+var LintSeverity = struct {
+	unknown,
+	info,
+	warning,
+	error string
+}{
+	unknown: "unknown",
+	info:    "info",
+	warning: "warning",
+	error:   "error",
+}
+
+type HelmLintMessage struct {
+	Severity string `json:"severity"`
+	Path     string `json:"path"`
+	Message  string `json:"message"`
+}
+
+type HelmLintResult struct {
+	Ref         string            `json:"ref"`
+	Passed      bool              `json:"passed"`
+	KubeVersion string            `json:"kubeVersion"`
+	Messages    []HelmLintMessage `json:"messages"`
+	Errors      []string          `json:"errors"`
+}
+
+func lintSeverity(severity int) string {
+	switch severity {
+	case support.InfoSev:
+		return LintSeverity.info
+	case support.WarningSev:
+		return LintSeverity.warning
+	case support.ErrorSev:
+		return LintSeverity.error
+	}
+	return LintSeverity.unknown
+}
+
+func clusterKubeVersion(config *rest.Config) (*chartutil.KubeVersion, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the kubernetes version of the cluster: %w", err)
+	}
+
+	return &chartutil.KubeVersion{
+		Version: info.GitVersion,
+		Major:   info.Major,
+		Minor:   info.Minor,
+	}, nil
+}
+
+// LintChart lints a chart of the chart cache with the given values against
+// the kubernetes version of the cluster and the release namespace.
+func (h *Helm) LintChart(config *rest.Config, opts HelmOptions, values map[string]interface{}) (HelmLintResult, error) {
+	chartPath, err := localChartPath(opts.LocalChart)
+	if err != nil {
+		return HelmLintResult{}, err
+	}
+
+	kubeVersion, err := clusterKubeVersion(config)
+	if err != nil {
+		return HelmLintResult{}, err
+	}
+
+	l := action.NewLint()
+	l.Strict = opts.Lint.Strict
+	l.Namespace = h.ReleaseNamespace
+	l.KubeVersion = kubeVersion
+
+	lr := l.Run([]string{chartPath}, values)
+
+	result := HelmLintResult{
+		Ref:         opts.LocalChart,
+		Passed:      len(lr.Errors) == 0,
+		KubeVersion: kubeVersion.Version,
+		Messages:    []HelmLintMessage{},
+		Errors:      []string{},
+	}
+	for _, msg := range lr.Messages {
+		result.Messages = append(result.Messages, HelmLintMessage{
+			Severity: lintSeverity(msg.Severity),
+			Path:     msg.Path,
+			Message:  msg.Err.Error(),
+		})
+	}
+	// Errors repeats the messages failing the lint, only the errors of charts
+	// that could not be linted at all are kept.
+	if lr.TotalChartsLinted == 0 {
+		for _, err := range lr.Errors {
+			result.Errors = append(result.Errors, strings.TrimSpace(err.Error()))
+		}
+	}
+
+	return result, nil
+}
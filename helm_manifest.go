@@ -0,0 +1,136 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+const defaultTemplateReleaseName = "release-name"
+
+type HelmResource struct {
+	Source     string   `json:"source"`
+	APIVersion string   `json:"apiVersion"`
+	Kind       string   `json:"kind"`
+	Name       string   `json:"name"`
+	Namespace  string   `json:"namespace"`
+	Events     []string `json:"events,omitempty"`
+	Manifest   string   `json:"manifest"`
+}
+
+type HelmManifest struct {
+	Manifest  string         `json:"manifest"`
+	Resources []HelmResource `json:"resources"`
+	Hooks     []HelmResource `json:"hooks"`
+	Notes     string         `json:"notes"`
+}
+
+type manifestHead struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+}
+
+func newHelmResource(source, manifest string) HelmResource {
+	hr := HelmResource{Source: source, Manifest: manifest}
+
+	var head manifestHead
+	if err := yaml.Unmarshal([]byte(manifest), &head); err == nil {
+		hr.APIVersion = head.APIVersion
+		hr.Kind = head.Kind
+		hr.Name = head.Metadata.Name
+		hr.Namespace = head.Metadata.Namespace
+	}
+
+	return hr
+}
+
+// splitHelmManifest splits a rendered release manifest into its resources,
+// keeping the template they were rendered from.
+func splitHelmManifest(manifest string) []HelmResource {
+	docs := releaseutil.SplitManifests(manifest)
+
+	keys := make([]string, 0, len(docs))
+	for k := range docs {
+		keys = append(keys, k)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	resources := []HelmResource{}
+	for _, k := range keys {
+		var source string
+		var lines []string
+		for _, line := range strings.Split(docs[k], "\n") {
+			if s, ok := strings.CutPrefix(line, "# Source: "); ok && source == "" {
+				source = s
+				continue
+			}
+			lines = append(lines, line)
+		}
+
+		doc := strings.TrimSpace(strings.Join(lines, "\n"))
+		if doc == "" {
+			continue
+		}
+		resources = append(resources, newHelmResource(source, doc+"\n"))
+	}
+
+	return resources
+}
+
+func newHelmManifest(rel *release.Release) HelmManifest {
+	hm := HelmManifest{
+		Manifest:  rel.Manifest,
+		Resources: splitHelmManifest(rel.Manifest),
+		Hooks:     []HelmResource{},
+	}
+	if rel.Info != nil {
+		hm.Notes = rel.Info.Notes
+	}
+
+	for _, hook := range rel.Hooks {
+		hr := newHelmResource(hook.Path, hook.Manifest)
+		for _, e := range hook.Events {
+			hr.Events = append(hr.Events, e.String())
+		}
+		hm.Hooks = append(hm.Hooks, hr)
+	}
+
+	return hm
+}
+
+// TemplateChart renders the chart as a dry-run install, so the templates see
+// the capabilities and API versions of the connected cluster.
+func (h *Helm) TemplateChart(config *rest.Config, opts HelmOptions, values map[string]interface{}) (rel *release.Release, err error) {
+	h.ActionConfig, err = GetActionConfig(h.ReleaseNamespace, config, h.debugLog())
+	if err != nil {
+		return nil, err
+	}
+
+	i := action.NewInstall(h.ActionConfig)
+	i.Version = opts.ChartVersion
+	i.ReleaseName = h.ReleaseName
+	if i.ReleaseName == "" {
+		i.ReleaseName = defaultTemplateReleaseName
+	}
+	i.Namespace = h.ReleaseNamespace
+	if i.Namespace == "" {
+		i.Namespace = metav1.NamespaceDefault
+	}
+	i.DryRun = true
+	i.Replace = true
+	i.IncludeCRDs = true
+	i.DependencyUpdate = opts.DependencyUpdate
+	i.ChartPathOptions.RepoURL = opts.RepoURL
+
+	return h.runInstall(i, opts, values)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"helm.sh/helm/v3/pkg/registry"
+	"k8s.io/client-go/rest"
+)
+
+// helmRegistryLock serializes writes to the registry config files.
+var helmRegistryLock sync.Mutex
+
+type HelmRegistryOptions struct {
+	URL                   string `json:"url"`
+	Username              string `json:"username"`
+	Password              string `json:"password"`
+	InsecureSkipTLSVerify bool   `json:"insecureSkipTLSVerify"`
+}
+
+// registryPlainHTTP reports whether the registry has to be reached over plain
+// HTTP, which is only the case for 'http://' URLs. 'oci://', 'https://' and
+// bare hosts use TLS.
+func registryPlainHTTP(rawURL string) bool {
+	return strings.HasPrefix(rawURL, "http://")
+}
+
+// ociReference turns a registry URL into the 'oci://' reference helm expects.
+func ociReference(rawURL string) string {
+	for _, scheme := range []string{"oci://", "https://", "http://"} {
+		if strings.HasPrefix(rawURL, scheme) {
+			return registry.OCIScheme + "://" + strings.TrimPrefix(rawURL, scheme)
+		}
+	}
+	return registry.OCIScheme + "://" + rawURL
+}
+
+func registryHost(rawURL string) string {
+	host, _, _ := strings.Cut(strings.TrimPrefix(ociReference(rawURL), registry.OCIScheme+"://"), "/")
+	return host
+}
+
+// registryConfigPath returns the registry credentials file of the browser
+// session, or of the proxy user when impersonating, so a login is never used
+// by other users.
+func registryConfigPath(sessionID string, identity *rest.ImpersonationConfig) string {
+	key := "session-" + sessionID
+	if identity != nil {
+		sum := sha256.Sum256([]byte(identity.UserName))
+		key = "user-" + hex.EncodeToString(sum[:])
+	}
+	return filepath.Join(homeDir, defaultHelmRegistry, "sessions", key, "config.json")
+}
+
+// scopeRegistryConfig points the helm settings of the cluster at the registry
+// credentials of the session.
+func (cr *ClusterRegistry) scopeRegistryConfig(ar *APIResource) {
+	if ar.Helm != nil && ar.Helm.EnvSettings != nil {
+		ar.Helm.EnvSettings.RegistryConfig = registryConfigPath(cr.SessionID, cr.Identity)
+	}
+}
+
+func (h *Helm) RegistryLogin(opts HelmRegistryOptions) (string, error) {
+	host := registryHost(opts.URL)
+	if host == "" {
+		return "", errors.New("registry URL is required")
+	}
+
+	plainHTTP := registryPlainHTTP(opts.URL)
+	rc, err := newRegistryClient(h.EnvSettings, plainHTTP, opts.InsecureSkipTLSVerify)
+	if err != nil {
+		return "", fmt.Errorf("failed to create registry: %w", err)
+	}
+
+	helmRegistryLock.Lock()
+	defer helmRegistryLock.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(h.EnvSettings.RegistryConfig), 0700); err != nil {
+		return "", err
+	}
+
+	err = rc.Login(host,
+		registry.LoginOptBasicAuth(opts.Username, opts.Password),
+		registry.LoginOptInsecure(plainHTTP || opts.InsecureSkipTLSVerify),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to log in to '%s': %w", host, err)
+	}
+
+	return host, nil
+}
+
+func (h *Helm) RegistryLogout(opts HelmRegistryOptions) (string, error) {
+	host := registryHost(opts.URL)
+	if host == "" {
+		return "", errors.New("registry URL is required")
+	}
+
+	rc, err := newRegistryClient(h.EnvSettings, registryPlainHTTP(opts.URL), opts.InsecureSkipTLSVerify)
+	if err != nil {
+		return "", fmt.Errorf("failed to create registry: %w", err)
+	}
+
+	helmRegistryLock.Lock()
+	defer helmRegistryLock.Unlock()
+
+	if err := rc.Logout(host); err != nil {
+		return "", fmt.Errorf("failed to log out of '%s': %w", host, err)
+	}
+
+	return host, nil
+}
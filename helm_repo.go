@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// helmRepoLock serializes writes to repositories.yaml, which is shared by
+// every session and cluster.
+var helmRepoLock sync.Mutex
+
+type HelmRepoOptions struct {
+	Name                  string `json:"name"`
+	URL                   string `json:"url"`
+	Username              string `json:"username"`
+	Password              string `json:"password"`
+	CAData                string `json:"caData"`
+	CertData              string `json:"certData"`
+	KeyData               string `json:"keyData"`
+	InsecureSkipTLSVerify bool   `json:"insecureSkipTLSVerify"`
+	PassCredentialsAll    bool   `json:"passCredentialsAll"`
+}
+
+type HelmRepository struct {
+	Name                  string `json:"name"`
+	URL                   string `json:"url"`
+	Username              string `json:"username"`
+	HasPassword           bool   `json:"hasPassword"`
+	HasCA                 bool   `json:"hasCA"`
+	HasClientCert         bool   `json:"hasClientCert"`
+	InsecureSkipTLSVerify bool   `json:"insecureSkipTLSVerify"`
+	PassCredentialsAll    bool   `json:"passCredentialsAll"`
+}
+
+type HelmRepoUpdateResult struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+func newHelmRepository(e *repo.Entry) HelmRepository {
+	return HelmRepository{
+		Name:                  e.Name,
+		URL:                   e.URL,
+		Username:              e.Username,
+		HasPassword:           e.Password != "",
+		HasCA:                 e.CAFile != "",
+		HasClientCert:         e.CertFile != "" && e.KeyFile != "",
+		InsecureSkipTLSVerify: e.InsecureSkipTLSverify,
+		PassCredentialsAll:    e.PassCredentialsAll,
+	}
+}
+
+func validRepoName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return fmt.Errorf("invalid repository name '%s'", name)
+	}
+	return nil
+}
+
+// repoChartRef reports whether the chart is referenced as 'repo/chart' of a
+// repository from repositories.yaml rather than by a repository URL.
+func repoChartRef(opts HelmOptions) bool {
+	return opts.RepoURL == "" && !opts.IsOCI && strings.Count(opts.ChartName, "/") == 1
+}
+
+func (h *Helm) loadRepoFile() (*repo.File, error) {
+	if _, err := os.Stat(h.EnvSettings.RepositoryConfig); errors.Is(err, os.ErrNotExist) {
+		return repo.NewFile(), nil
+	}
+	return repo.LoadFile(h.EnvSettings.RepositoryConfig)
+}
+
+func (h *Helm) repoCertDir(name string) string {
+	return filepath.Join(filepath.Dir(h.EnvSettings.RepositoryConfig), "certs", name)
+}
+
+// writeRepoCerts writes the certificates of the repository to dir and points
+// the entry at them.
+func writeRepoCerts(opts HelmRepoOptions, entry *repo.Entry, dir string) error {
+	certs := []struct {
+		data string
+		file string
+		path *string
+	}{
+		{opts.CAData, "ca.crt", &entry.CAFile},
+		{opts.CertData, "tls.crt", &entry.CertFile},
+		{opts.KeyData, "tls.key", &entry.KeyFile},
+	}
+
+	for _, c := range certs {
+		if c.data == "" {
+			continue
+		}
+		*c.path = filepath.Join(dir, c.file)
+		if err := os.WriteFile(*c.path, []byte(c.data), 0600); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// moveRepoCerts replaces the certificates of the repository with the ones
+// staged in dir and points the entry at their final location.
+func (h *Helm) moveRepoCerts(entry *repo.Entry, dir string) error {
+	certDir := h.repoCertDir(entry.Name)
+	if err := os.RemoveAll(certDir); err != nil {
+		return err
+	}
+	if entry.CAFile == "" && entry.CertFile == "" {
+		return nil
+	}
+	if err := os.Rename(dir, certDir); err != nil {
+		return err
+	}
+
+	for _, path := range []*string{&entry.CAFile, &entry.CertFile, &entry.KeyFile} {
+		if *path != "" {
+			*path = filepath.Join(certDir, filepath.Base(*path))
+		}
+	}
+	return nil
+}
+
+func (h *Helm) downloadRepoIndex(entry *repo.Entry) error {
+	cr, err := repo.NewChartRepository(entry, getter.All(h.EnvSettings))
+	if err != nil {
+		return err
+	}
+	cr.CachePath = h.EnvSettings.RepositoryCache
+
+	if _, err := cr.DownloadIndexFile(); err != nil {
+		return fmt.Errorf("'%s' is not a valid chart repository or cannot be reached: %w", entry.URL, err)
+	}
+	return nil
+}
+
+// AddRepository adds the repository to repositories.yaml after fetching its
+// index, an existing repository with the same name is replaced.
+func (h *Helm) AddRepository(opts HelmRepoOptions) (HelmRepository, error) {
+	if err := validRepoName(opts.Name); err != nil {
+		return HelmRepository{}, err
+	}
+	if opts.URL == "" {
+		return HelmRepository{}, errors.New("repository URL is required")
+	}
+	if (opts.CertData == "") != (opts.KeyData == "") {
+		return HelmRepository{}, errors.New("client certificate and key must be set together")
+	}
+
+	helmRepoLock.Lock()
+	defer helmRepoLock.Unlock()
+
+	f, err := h.loadRepoFile()
+	if err != nil {
+		return HelmRepository{}, err
+	}
+
+	entry := &repo.Entry{
+		Name:                  opts.Name,
+		URL:                   strings.TrimSuffix(opts.URL, "/"),
+		Username:              opts.Username,
+		Password:              opts.Password,
+		InsecureSkipTLSverify: opts.InsecureSkipTLSVerify,
+		PassCredentialsAll:    opts.PassCredentialsAll,
+	}
+
+	// The certificates are staged until the index download succeeded, so a
+	// failed re-add leaves the certificates of the existing repository alone.
+	certsDir := filepath.Dir(h.repoCertDir(opts.Name))
+	if err := os.MkdirAll(certsDir, 0700); err != nil {
+		return HelmRepository{}, fmt.Errorf("failed to store repository certificates: %w", err)
+	}
+	stageDir, err := os.MkdirTemp(certsDir, ".stage-")
+	if err != nil {
+		return HelmRepository{}, fmt.Errorf("failed to store repository certificates: %w", err)
+	}
+	defer os.RemoveAll(stageDir)
+
+	if err := writeRepoCerts(opts, entry, stageDir); err != nil {
+		return HelmRepository{}, fmt.Errorf("failed to store repository certificates: %w", err)
+	}
+
+	if err := h.downloadRepoIndex(entry); err != nil {
+		return HelmRepository{}, err
+	}
+
+	if err := h.moveRepoCerts(entry, stageDir); err != nil {
+		return HelmRepository{}, fmt.Errorf("failed to store repository certificates: %w", err)
+	}
+
+	f.Update(entry)
+	if err := f.WriteFile(h.EnvSettings.RepositoryConfig, 0600); err != nil {
+		return HelmRepository{}, err
+	}
+
+	return newHelmRepository(entry), nil
+}
+
+func (h *Helm) ListRepositories() ([]HelmRepository, error) {
+	helmRepoLock.Lock()
+	defer helmRepoLock.Unlock()
+
+	f, err := h.loadRepoFile()
+	if err != nil {
+		return nil, err
+	}
+
+	repos := []HelmRepository{}
+	for _, e := range f.Repositories {
+		repos = append(repos, newHelmRepository(e))
+	}
+	return repos, nil
+}
+
+// UpdateRepositories refreshes the cached index of the named repository, or
+// of every repository when the name is empty.
+func (h *Helm) UpdateRepositories(name string) ([]HelmRepoUpdateResult, error) {
+	helmRepoLock.Lock()
+	defer helmRepoLock.Unlock()
+
+	f, err := h.loadRepoFile()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := f.Repositories
+	if name != "" {
+		e := f.Get(name)
+		if e == nil {
+			return nil, fmt.Errorf("no repository named '%s'", name)
+		}
+		entries = []*repo.Entry{e}
+	}
+
+	results := []HelmRepoUpdateResult{}
+	for _, e := range entries {
+		result := HelmRepoUpdateResult{Name: e.Name}
+		if err := h.downloadRepoIndex(e); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (h *Helm) RemoveRepository(name string) error {
+	if err := validRepoName(name); err != nil {
+		return err
+	}
+
+	helmRepoLock.Lock()
+	defer helmRepoLock.Unlock()
+
+	f, err := h.loadRepoFile()
+	if err != nil {
+		return err
+	}
+
+	if !f.Remove(name) {
+		return fmt.Errorf("no repository named '%s'", name)
+	}
+	if err := f.WriteFile(h.EnvSettings.RepositoryConfig, 0600); err != nil {
+		return err
+	}
+
+	os.Remove(filepath.Join(h.EnvSettings.RepositoryCache, helmpath.CacheIndexFile(name)))
+	os.Remove(filepath.Join(h.EnvSettings.RepositoryCache, helmpath.CacheChartsFile(name)))
+	os.RemoveAll(h.repoCertDir(name))
+
+	return nil
+}
+
+// repoEntry returns the repositories.yaml entry and chart name of a
+// 'repo/chart' reference.
+func (h *Helm) repoEntry(chartRef string) (*repo.Entry, string, error) {
+	repoName, chartName, _ := strings.Cut(chartRef, "/")
+
+	helmRepoLock.Lock()
+	defer helmRepoLock.Unlock()
+
+	f, err := h.loadRepoFile()
+	if err != nil {
+		return nil, "", err
+	}
+
+	e := f.Get(repoName)
+	if e == nil {
+		return nil, "", fmt.Errorf("no repository named '%s'", repoName)
+	}
+	return e, chartName, nil
+}
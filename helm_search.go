@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+const (
+	defaultArtifactHubURL = "https://artifacthub.io"
+	defaultSearchLimit    = 50
+	artifactHubTimeout    = 15 * time.Second
+)
+
+var ChartSearchSource = struct {
+	repository,
+	artifactHub string
+}{
+	repository:  "repository",
+	artifactHub: "artifactHub",
+}
+
+type HelmSearchOptions struct {
+	Query       string `json:"query"`
+	Versions    bool   `json:"versions"`
+	ArtifactHub bool   `json:"artifactHub"`
+	Limit       int    `json:"limit"`
+}
+
+type ChartSearchResult struct {
+	Source      string    `json:"source"`
+	Repository  string    `json:"repository"`
+	RepoURL     string    `json:"repoURL"`
+	Name        string    `json:"name"`
+	ChartName   string    `json:"chartName"`
+	Version     string    `json:"version"`
+	AppVersion  string    `json:"appVersion"`
+	Description string    `json:"description"`
+	Keywords    []string  `json:"keywords"`
+	Created     time.Time `json:"created"`
+	Deprecated  bool      `json:"deprecated"`
+	Icon        string    `json:"icon"`
+}
+
+type ChartSearch struct {
+	Results []ChartSearchResult `json:"results"`
+	// Errors holds the sources that could not be searched, the results of the
+	// other sources are still returned.
+	Errors []string `json:"errors"`
+}
+
+func chartMatches(cv *repo.ChartVersion, terms []string) bool {
+	text := strings.ToLower(strings.Join(append([]string{cv.Name, cv.Description}, cv.Keywords...), " "))
+	for _, term := range terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// SearchRepositories searches the cached index files of the repositories from
+// repositories.yaml. Every term of the query has to match the name,
+// description or keywords of the chart.
+func (h *Helm) SearchRepositories(opts HelmSearchOptions) (cs ChartSearch, err error) {
+	helmRepoLock.Lock()
+	f, err := h.loadRepoFile()
+	helmRepoLock.Unlock()
+	if err != nil {
+		return cs, err
+	}
+
+	terms := strings.Fields(strings.ToLower(opts.Query))
+	cs.Results = []ChartSearchResult{}
+	cs.Errors = []string{}
+
+	for _, e := range f.Repositories {
+		idx, err := repo.LoadIndexFile(filepath.Join(h.EnvSettings.RepositoryCache, helmpath.CacheIndexFile(e.Name)))
+		if err != nil {
+			cs.Errors = append(cs.Errors, fmt.Sprintf("repository '%s': %s", e.Name, err))
+			continue
+		}
+
+		for chartName, versions := range idx.Entries {
+			for _, cv := range versions {
+				if !chartMatches(cv, terms) {
+					continue
+				}
+
+				cs.Results = append(cs.Results, ChartSearchResult{
+					Source:      ChartSearchSource.repository,
+					Repository:  e.Name,
+					RepoURL:     e.URL,
+					Name:        e.Name + "/" + chartName,
+					ChartName:   chartName,
+					Version:     cv.Version,
+					AppVersion:  cv.AppVersion,
+					Description: cv.Description,
+					Keywords:    cv.Keywords,
+					Created:     cv.Created,
+					Deprecated:  cv.Deprecated,
+					Icon:        cv.Icon,
+				})
+
+				// Index entries are sorted newest first.
+				if !opts.Versions {
+					break
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(cs.Results, func(i, j int) bool {
+		return cs.Results[i].Name < cs.Results[j].Name
+	})
+
+	cs.Results = limitSearchResults(cs.Results, opts)
+
+	return cs, nil
+}
+
+func searchLimit(opts HelmSearchOptions) int {
+	if opts.Limit <= 0 {
+		return defaultSearchLimit
+	}
+	return opts.Limit
+}
+
+func limitSearchResults(results []ChartSearchResult, opts HelmSearchOptions) []ChartSearchResult {
+	if limit := searchLimit(opts); len(results) > limit {
+		return results[:limit]
+	}
+	return results
+}
+
+type artifactHubPackage struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+	AppVersion  string `json:"app_version"`
+	Deprecated  bool   `json:"deprecated"`
+	LogoImageID string `json:"logo_image_id"`
+	TS          int64  `json:"ts"`
+	Repository  struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	} `json:"repository"`
+}
+
+type artifactHubSearch struct {
+	Packages []artifactHubPackage `json:"packages"`
+}
+
+// SearchArtifactHub queries the packages search API of an Artifact Hub
+// compatible server for helm charts.
+func SearchArtifactHub(baseURL string, opts HelmSearchOptions) ([]ChartSearchResult, error) {
+	if baseURL == "" {
+		return nil, errors.New("artifact hub search is disabled")
+	}
+
+	query := url.Values{}
+	query.Set("ts_query_web", opts.Query)
+	query.Set("kind", "0")
+	query.Set("limit", strconv.Itoa(searchLimit(opts)))
+	query.Set("offset", "0")
+
+	searchURL := strings.TrimSuffix(baseURL, "/") + "/api/v1/packages/search?" + query.Encode()
+
+	client := &http.Client{Timeout: artifactHubTimeout, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}}
+	resp, err := client.Get(searchURL)
+	if err != nil {
+		return nil, fmt.Errorf("artifact hub search failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("artifact hub search failed: %s", resp.Status)
+	}
+
+	var ahs artifactHubSearch
+	if err := json.NewDecoder(resp.Body).Decode(&ahs); err != nil {
+		return nil, fmt.Errorf("invalid artifact hub search response: %w", err)
+	}
+
+	results := []ChartSearchResult{}
+	for _, p := range ahs.Packages {
+		result := ChartSearchResult{
+			Source:      ChartSearchSource.artifactHub,
+			Repository:  p.Repository.Name,
+			RepoURL:     p.Repository.URL,
+			Name:        p.Repository.Name + "/" + p.Name,
+			ChartName:   p.Name,
+			Version:     p.Version,
+			AppVersion:  p.AppVersion,
+			Description: p.Description,
+			Deprecated:  p.Deprecated,
+		}
+		if p.TS > 0 {
+			result.Created = time.Unix(p.TS, 0).UTC()
+		}
+		if p.LogoImageID != "" {
+			result.Icon = strings.TrimSuffix(baseURL, "/") + "/image/" + p.LogoImageID
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// SearchCharts searches the configured repositories and, when asked for, the
+// Artifact Hub server set with the start flags.
+func (h *Helm) SearchCharts(opts HelmSearchOptions) (ChartSearch, error) {
+	cs, err := h.SearchRepositories(opts)
+	if err != nil {
+		return cs, err
+	}
+
+	if opts.ArtifactHub {
+		results, err := SearchArtifactHub(artifactHubURLFlagValue, opts)
+		if err != nil {
+			cs.Errors = append(cs.Errors, err.Error())
+		}
+		// The limit applies to the merged results, not to every source.
+		cs.Results = limitSearchResults(append(cs.Results, results...), opts)
+	}
+
+	return cs, nil
+}
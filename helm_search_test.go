@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// newSearchHelm writes a repositories.yaml with one repository and its cached
+// index file to a temporary helm home.
+func newSearchHelm(t *testing.T, charts []*chart.Metadata) *Helm {
+	t.Helper()
+
+	dir := t.TempDir()
+	h := &Helm{EnvSettings: &cli.EnvSettings{
+		RepositoryConfig: filepath.Join(dir, "repositories.yaml"),
+		RepositoryCache:  dir,
+	}}
+
+	f := repo.NewFile()
+	f.Add(&repo.Entry{Name: "stable", URL: "https://charts.example.com"})
+	if err := f.WriteFile(h.EnvSettings.RepositoryConfig, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := repo.NewIndexFile()
+	for _, md := range charts {
+		md.APIVersion = chart.APIVersionV2
+		filename := fmt.Sprintf("%s-%s.tgz", md.Name, md.Version)
+		if err := idx.MustAdd(md, filename, "https://charts.example.com", "sha256:0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := idx.WriteFile(filepath.Join(dir, helmpath.CacheIndexFile("stable")), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+var searchCharts = []*chart.Metadata{
+	{Name: "nginx", Version: "1.0.0", Description: "Web server"},
+	{Name: "nginx", Version: "1.2.0", Description: "Web server"},
+	{Name: "redis", Version: "2.0.0", Description: "In-memory data store", Keywords: []string{"cache", "database"}},
+	{Name: "postgres", Version: "3.0.0", Description: "Relational database"},
+	{Name: "traefik", Version: "4.0.0", Description: "Edge router", Keywords: []string{"proxy", "ingress"}},
+}
+
+func searchNames(results []ChartSearchResult) []string {
+	names := []string{}
+	for _, r := range results {
+		names = append(names, r.Name+"@"+r.Version)
+	}
+	return names
+}
+
+func TestSearchRepositories(t *testing.T) {
+	h := newSearchHelm(t, searchCharts)
+
+	tests := []struct {
+		name string
+		opts HelmSearchOptions
+		want []string
+	}{
+		{
+			name: "name",
+			opts: HelmSearchOptions{Query: "nginx"},
+			want: []string{"stable/nginx@1.2.0"},
+		},
+		{
+			name: "description",
+			opts: HelmSearchOptions{Query: "RELATIONAL"},
+			want: []string{"stable/postgres@3.0.0"},
+		},
+		{
+			name: "keyword",
+			opts: HelmSearchOptions{Query: "cache"},
+			want: []string{"stable/redis@2.0.0"},
+		},
+		{
+			name: "every term has to match",
+			opts: HelmSearchOptions{Query: "database cache"},
+			want: []string{"stable/redis@2.0.0"},
+		},
+		{
+			name: "term matching description and keyword",
+			opts: HelmSearchOptions{Query: "database"},
+			want: []string{"stable/postgres@3.0.0", "stable/redis@2.0.0"},
+		},
+		{
+			name: "all versions",
+			opts: HelmSearchOptions{Query: "nginx", Versions: true},
+			want: []string{"stable/nginx@1.2.0", "stable/nginx@1.0.0"},
+		},
+		{
+			name: "no match",
+			opts: HelmSearchOptions{Query: "mysql"},
+			want: []string{},
+		},
+		{
+			name: "limit",
+			opts: HelmSearchOptions{Limit: 2},
+			want: []string{"stable/nginx@1.2.0", "stable/postgres@3.0.0"},
+		},
+		{
+			name: "limit with versions",
+			opts: HelmSearchOptions{Versions: true, Limit: 3},
+			want: []string{"stable/nginx@1.2.0", "stable/nginx@1.0.0", "stable/postgres@3.0.0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs, err := h.SearchRepositories(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cs.Errors) != 0 {
+				t.Fatalf("unexpected errors: %v", cs.Errors)
+			}
+			if got := searchNames(cs.Results); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchRepositoriesMissingIndex(t *testing.T) {
+	h := newSearchHelm(t, searchCharts)
+
+	f, err := h.loadRepoFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Add(&repo.Entry{Name: "missing", URL: "https://missing.example.com"})
+	if err := f.WriteFile(h.EnvSettings.RepositoryConfig, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cs, err := h.SearchRepositories(HelmSearchOptions{Query: "nginx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs.Errors) != 1 || !strings.Contains(cs.Errors[0], "'missing'") {
+		t.Errorf("got errors %v, want one for the missing repository", cs.Errors)
+	}
+	if len(cs.Results) != 1 {
+		t.Errorf("got %d results, want the results of the other repository", len(cs.Results))
+	}
+}
+
+const artifactHubResponse = `{
+	"packages": [
+		{
+			"name": "cert-manager",
+			"description": "A Helm chart for cert-manager",
+			"version": "v1.15.1",
+			"app_version": "v1.15.1",
+			"deprecated": false,
+			"logo_image_id": "abc-123",
+			"ts": 1719410000,
+			"repository": {"name": "cert-manager", "url": "https://charts.jetstack.io"}
+		},
+		{
+			"name": "old-chart",
+			"version": "0.1.0",
+			"deprecated": true,
+			"repository": {"name": "legacy", "url": "https://legacy.example.com"}
+		}
+	]
+}`
+
+func TestSearchArtifactHub(t *testing.T) {
+	var query map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/packages/search" {
+			http.NotFound(w, r)
+			return
+		}
+		query = map[string]string{}
+		for k := range r.URL.Query() {
+			query[k] = r.URL.Query().Get(k)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, artifactHubResponse)
+	}))
+	defer srv.Close()
+
+	results, err := SearchArtifactHub(srv.URL+"/", HelmSearchOptions{Query: "cert manager", Limit: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, want := range map[string]string{"ts_query_web": "cert manager", "kind": "0", "limit": "7", "offset": "0"} {
+		if query[k] != want {
+			t.Errorf("query parameter %s = %q, want %q", k, query[k], want)
+		}
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+
+	got := results[0]
+	want := ChartSearchResult{
+		Source:      ChartSearchSource.artifactHub,
+		Repository:  "cert-manager",
+		RepoURL:     "https://charts.jetstack.io",
+		Name:        "cert-manager/cert-manager",
+		ChartName:   "cert-manager",
+		Version:     "v1.15.1",
+		AppVersion:  "v1.15.1",
+		Description: "A Helm chart for cert-manager",
+		Created:     time.Unix(1719410000, 0).UTC(),
+		Icon:        srv.URL + "/image/abc-123",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	old := results[1]
+	if !old.Deprecated || old.Icon != "" || !old.Created.IsZero() {
+		t.Errorf("got %+v, want a deprecated result without icon and creation time", old)
+	}
+}
+
+func TestSearchArtifactHubDefaultLimit(t *testing.T) {
+	var limit string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit = r.URL.Query().Get("limit")
+		fmt.Fprint(w, `{"packages": []}`)
+	}))
+	defer srv.Close()
+
+	results, err := SearchArtifactHub(srv.URL, HelmSearchOptions{Query: "nginx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results == nil || len(results) != 0 {
+		t.Errorf("got %v, want an empty result list", results)
+	}
+	if limit != fmt.Sprint(defaultSearchLimit) {
+		t.Errorf("limit = %q, want %d", limit, defaultSearchLimit)
+	}
+}
+
+func TestSearchArtifactHubErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{
+			name: "status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "down", http.StatusServiceUnavailable)
+			},
+			want: "503 Service Unavailable",
+		},
+		{
+			name: "invalid json",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"packages": [`)
+			},
+			want: "invalid artifact hub search response",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			_, err := SearchArtifactHub(srv.URL, HelmSearchOptions{Query: "nginx"})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := SearchArtifactHub("", HelmSearchOptions{}); err == nil {
+		t.Error("expected an error when artifact hub search is disabled")
+	}
+}
+
+func TestSearchChartsLimitsMergedResults(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, artifactHubResponse)
+	}))
+	defer srv.Close()
+
+	defer func(old string) { artifactHubURLFlagValue = old }(artifactHubURLFlagValue)
+	artifactHubURLFlagValue = srv.URL
+
+	h := newSearchHelm(t, searchCharts)
+
+	cs, err := h.SearchCharts(HelmSearchOptions{ArtifactHub: true, Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs.Results) != 3 {
+		t.Errorf("got %d results, want 3: %v", len(cs.Results), searchNames(cs.Results))
+	}
+
+	cs, err = h.SearchCharts(HelmSearchOptions{Query: "nginx", ArtifactHub: true, Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"stable/nginx@1.2.0", "cert-manager/cert-manager@v1.15.1", "legacy/old-chart@0.1.0"}
+	if got := searchNames(cs.Results); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
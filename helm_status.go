@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+	"k8s.io/kubectl/pkg/scheme"
+)
+
+type HelmResourceStatus struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	Exists     bool   `json:"exists"`
+	Ready      bool   `json:"ready"`
+	Error      string `json:"error,omitempty"`
+}
+
+type HelmStatus struct {
+	Name          string               `json:"name"`
+	Namespace     string               `json:"namespace"`
+	Revision      int                  `json:"revision"`
+	Status        string               `json:"status"`
+	Description   string               `json:"description"`
+	Notes         string               `json:"notes"`
+	FirstDeployed time.Time            `json:"firstDeployed"`
+	LastDeployed  time.Time            `json:"lastDeployed"`
+	Ready         bool                 `json:"ready"`
+	Resources     []HelmResourceStatus `json:"resources"`
+}
+
+type HelmTestLog struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	SessionID string `json:"sessionId"`
+	Error     string `json:"error,omitempty"`
+}
+
+type HelmTestHook struct {
+	Name        string        `json:"name"`
+	Kind        string        `json:"kind"`
+	Phase       string        `json:"phase"`
+	StartedAt   time.Time     `json:"startedAt"`
+	CompletedAt time.Time     `json:"completedAt"`
+	Logs        []HelmTestLog `json:"logs"`
+}
+
+type HelmTestResult struct {
+	Name      string         `json:"name"`
+	Namespace string         `json:"namespace"`
+	Passed    bool           `json:"passed"`
+	Tests     []HelmTestHook `json:"tests"`
+}
+
+// ReleaseStatus returns the release with the live state of its resources,
+// readiness is checked the same way helm does when waiting for a release.
+func (h *Helm) ReleaseStatus(config *rest.Config) (hs HelmStatus, err error) {
+	h.ActionConfig, err = GetActionConfig(h.ReleaseNamespace, config, h.debugLog())
+	if err != nil {
+		return hs, err
+	}
+
+	sc := action.NewStatus(h.ActionConfig)
+	sc.Version = h.ReleaseVersion
+
+	rel, err := sc.Run(h.ReleaseName)
+	if err != nil {
+		return hs, err
+	}
+
+	hs.Name = rel.Name
+	hs.Namespace = rel.Namespace
+	hs.Revision = rel.Version
+	if rel.Info != nil {
+		hs.Status = rel.Info.Status.String()
+		hs.Description = rel.Info.Description
+		hs.Notes = rel.Info.Notes
+		hs.FirstDeployed = rel.Info.FirstDeployed.Time
+		hs.LastDeployed = rel.Info.LastDeployed.Time
+	}
+
+	resources, err := h.ActionConfig.KubeClient.Build(bytes.NewBufferString(rel.Manifest), false)
+	if err != nil {
+		return hs, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return hs, err
+	}
+	rc := kube.NewReadyChecker(clientset, h.debugLog(), kube.PausedAsReady(true), kube.CheckJobs(true))
+
+	hs.Ready = true
+	hs.Resources = []HelmResourceStatus{}
+	for _, info := range resources {
+		rs := HelmResourceStatus{
+			APIVersion: info.Mapping.GroupVersionKind.GroupVersion().String(),
+			Kind:       info.Mapping.GroupVersionKind.Kind,
+			Name:       info.Name,
+			Namespace:  info.Namespace,
+		}
+
+		if err := info.Get(); err != nil {
+			if !apierrors.IsNotFound(err) {
+				rs.Error = err.Error()
+			}
+			hs.Ready = false
+			hs.Resources = append(hs.Resources, rs)
+			continue
+		}
+		rs.Exists = true
+
+		ready, err := rc.IsReady(h.context(), info)
+		if err != nil {
+			rs.Error = err.Error()
+		}
+		rs.Ready = ready
+		hs.Ready = hs.Ready && ready
+
+		hs.Resources = append(hs.Resources, rs)
+	}
+
+	return hs, nil
+}
+
+func (h *Helm) TestRelease(config *rest.Config) (rel *release.Release, err error) {
+	h.ActionConfig, err = GetActionConfig(h.ReleaseNamespace, config, h.debugLog())
+	if err != nil {
+		return nil, err
+	}
+
+	rt := action.NewReleaseTesting(h.ActionConfig)
+	rt.Namespace = h.ReleaseNamespace
+	rt.Timeout = h.Timeout
+
+	return rt.Run(h.ReleaseName)
+}
+
+func isTestHook(hook *release.Hook) bool {
+	for _, e := range hook.Events {
+		if e == release.HookTest {
+			return true
+		}
+	}
+	return false
+}
+
+// testPodLogs starts a following log session for every container of the test
+// pods of a release as soon as the container runs, so the logs are streamed
+// while the tests run. Pods deleted by the hook delete policy still had their
+// logs streamed until then.
+type testPodLogs struct {
+	client  kubernetes.Interface
+	rel     *release.Release
+	onStart func(HelmTestLog)
+	cancel  context.CancelFunc
+	done    chan struct{}
+	lock    sync.Mutex
+	logs    map[string][]HelmTestLog
+	started map[string]bool
+}
+
+func watchTestPods(ctx context.Context, client kubernetes.Interface, rel *release.Release, onStart func(HelmTestLog)) (*testPodLogs, error) {
+	// Listing first makes the watch start after the pods of earlier test
+	// runs, which are not reported as added then.
+	pods, err := client.CoreV1().Pods(rel.Namespace).List(ctx, metav1.ListOptions{Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	tpl := &testPodLogs{
+		client:  client,
+		rel:     rel,
+		onStart: onStart,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		logs:    make(map[string][]HelmTestLog),
+		started: make(map[string]bool),
+	}
+
+	rw, err := watchtools.NewRetryWatcher(pods.ResourceVersion, &cache.ListWatch{
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.CoreV1().Pods(rel.Namespace).Watch(ctx, options)
+		},
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	hooks := make(map[string]bool)
+	for _, hook := range rel.Hooks {
+		if isTestHook(hook) && hook.Kind == "Pod" {
+			hooks[hook.Name] = true
+		}
+	}
+
+	go func() {
+		defer close(tpl.done)
+		defer rw.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-rw.ResultChan():
+				if !ok {
+					return
+				}
+				if event.Type != watch.Added && event.Type != watch.Modified {
+					continue
+				}
+				if pod, ok := event.Object.(*corev1.Pod); ok && hooks[pod.Name] {
+					tpl.startPod(pod, true)
+				}
+			}
+		}
+	}()
+
+	return tpl, nil
+}
+
+// startPod starts the log sessions of the containers of the pod that ran,
+// all of them when the pod is not followed anymore.
+func (tpl *testPodLogs) startPod(pod *corev1.Pod, follow bool) {
+	running := make(map[string]bool)
+	for _, cs := range pod.Status.ContainerStatuses {
+		running[cs.Name] = cs.State.Running != nil || cs.State.Terminated != nil
+	}
+
+	tpl.lock.Lock()
+	defer tpl.lock.Unlock()
+
+	for _, c := range pod.Spec.Containers {
+		key := pod.Name + "/" + c.Name
+		if tpl.started[key] || (follow && !running[c.Name]) {
+			continue
+		}
+		tpl.started[key] = true
+
+		tl := HelmTestLog{Pod: pod.Name, Container: c.Name}
+		sessionID, err := newLogSession(tpl.client, &PodLogsData{
+			Namespace:      tpl.rel.Namespace,
+			Name:           pod.Name,
+			ResourceType:   "pods",
+			Options:        &corev1.PodLogOptions{Container: c.Name, Follow: follow},
+			ParameterCodec: scheme.ParameterCodec,
+		})
+		if err != nil {
+			tl.Error = err.Error()
+		}
+		tl.SessionID = sessionID
+
+		tpl.logs[pod.Name] = append(tpl.logs[pod.Name], tl)
+		if tpl.onStart != nil && err == nil {
+			tpl.onStart(tl)
+		}
+	}
+}
+
+func (tpl *testPodLogs) Stop() {
+	tpl.cancel()
+	<-tpl.done
+}
+
+// testHooks lists the test hooks of the release with the log sessions of
+// their pods. Test pods that were not picked up while the tests ran get log
+// sessions now, pods that are gone already get an error entry.
+func testHooks(client kubernetes.Interface, rel *release.Release, tpl *testPodLogs) []HelmTestHook {
+	tests := []HelmTestHook{}
+	for _, hook := range rel.Hooks {
+		if !isTestHook(hook) {
+			continue
+		}
+
+		th := HelmTestHook{
+			Name:        hook.Name,
+			Kind:        hook.Kind,
+			Phase:       hook.LastRun.Phase.String(),
+			StartedAt:   hook.LastRun.StartedAt.Time,
+			CompletedAt: hook.LastRun.CompletedAt.Time,
+			Logs:        []HelmTestLog{},
+		}
+
+		if hook.Kind == "Pod" {
+			pod, err := client.CoreV1().Pods(rel.Namespace).Get(context.Background(), hook.Name, metav1.GetOptions{})
+			if err == nil {
+				tpl.startPod(pod, false)
+			}
+
+			tpl.lock.Lock()
+			th.Logs = append(th.Logs, tpl.logs[hook.Name]...)
+			tpl.lock.Unlock()
+
+			if len(th.Logs) == 0 {
+				tl := HelmTestLog{Pod: hook.Name, Error: fmt.Sprintf("test pod '%s' was deleted before its logs could be read", hook.Name)}
+				if err != nil && !apierrors.IsNotFound(err) {
+					tl.Error = err.Error()
+				}
+				th.Logs = append(th.Logs, tl)
+			}
+		}
+
+		tests = append(tests, th)
+	}
+
+	return tests
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+var ValuesErrorSeverity = struct {
+	error,
+	warning string
+}{
+	error:   "error",
+	warning: "warning",
+}
+
+const unknownValueKey = "unknownKey"
+
+type ValuesFieldError struct {
+	Chart    string `json:"chart"`
+	Path     string `json:"path"`
+	Reason   string `json:"reason"`
+	Message  string `json:"message"`
+	Severity string `json:"severity"`
+}
+
+type ValuesValidation struct {
+	Valid     bool               `json:"valid"`
+	HasSchema bool               `json:"hasSchema"`
+	Errors    []ValuesFieldError `json:"errors"`
+}
+
+type ValuesDiff struct {
+	Defaults map[string]interface{} `json:"defaults"`
+	User     map[string]interface{} `json:"user"`
+	Deployed map[string]interface{} `json:"deployed"`
+	// Overrides are the changes the user values make to the chart defaults.
+	Overrides []FieldChange `json:"overrides"`
+	// Changes are the changes of the user values to the config of the
+	// deployed release, empty when the release is not installed yet.
+	Changes []FieldChange `json:"changes"`
+	Unified string        `json:"unified"`
+}
+
+func (h *Helm) loadChart(config *rest.Config, opts HelmOptions) (chrt *chart.Chart, err error) {
+	h.ActionConfig, err = GetActionConfig(h.ReleaseNamespace, config, h.debugLog())
+	if err != nil {
+		return nil, err
+	}
+
+	sc := action.NewShow(action.ShowAll)
+	sc.Version = opts.ChartVersion
+	sc.ChartPathOptions.RepoURL = opts.RepoURL
+
+	chartRef := opts.ChartName
+	if opts.IsOCI {
+		rc, errReg := newRegistryClient(h.EnvSettings, registryPlainHTTP(opts.RepoURL), opts.Registry.InsecureSkipTLSVerify)
+		if errReg != nil {
+			return nil, fmt.Errorf("failed to create registry: %w", errReg)
+		}
+		sc.SetRegistryClient(rc)
+		chartRef = ociReference(opts.RepoURL)
+		sc.ChartPathOptions.RepoURL = ""
+	}
+
+	cp, err := h.locateChart(&sc.ChartPathOptions, chartRef, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate chart: %w", err)
+	}
+
+	chrt, err = loader.Load(cp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart: %w", err)
+	}
+
+	return chrt, nil
+}
+
+// schemaPathSegments turns a gojsonschema context like '(root).image.tag' into
+// path segments, list indexes become ints.
+func schemaPathSegments(context string) []interface{} {
+	var segments []interface{}
+	for _, seg := range strings.Split(context, ".") {
+		if seg == "" || seg == gojsonschema.STRING_CONTEXT_ROOT {
+			continue
+		}
+		if i, err := strconv.Atoi(seg); err == nil {
+			segments = append(segments, i)
+			continue
+		}
+		segments = append(segments, seg)
+	}
+	return segments
+}
+
+func validateSchema(chrt *chart.Chart, values map[string]interface{}, prefix []interface{}) ([]ValuesFieldError, error) {
+	var fieldErrors []ValuesFieldError
+
+	if len(chrt.Schema) > 0 {
+		result, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(chrt.Schema), gojsonschema.NewGoLoader(values))
+		if err != nil {
+			return nil, fmt.Errorf("invalid values schema of chart '%s': %w", chrt.Name(), err)
+		}
+
+		for _, re := range result.Errors() {
+			segments := append(append([]interface{}{}, prefix...), schemaPathSegments(re.Context().String())...)
+			if property, ok := re.Details()["property"].(string); ok && re.Type() == "required" {
+				segments = appendSegment(segments, property)
+			}
+
+			fieldErrors = append(fieldErrors, ValuesFieldError{
+				Chart:    chrt.Name(),
+				Path:     formatFieldPath(segments),
+				Reason:   re.Type(),
+				Message:  re.Description(),
+				Severity: ValuesErrorSeverity.error,
+			})
+		}
+	}
+
+	for _, sub := range chrt.Dependencies() {
+		subValues, _ := values[sub.Name()].(map[string]interface{})
+		subErrors, err := validateSchema(sub, subValues, appendSegment(prefix, sub.Name()))
+		if err != nil {
+			return nil, err
+		}
+		fieldErrors = append(fieldErrors, subErrors...)
+	}
+
+	return fieldErrors, nil
+}
+
+// unknownValueKeys reports the user keys that the chart defaults don't know
+// about. Maps that are empty by default are free-form and not checked.
+func unknownValueKeys(chrt *chart.Chart, defaults, values map[string]interface{}, segments []interface{}) []ValuesFieldError {
+	if len(defaults) == 0 {
+		return nil
+	}
+
+	subcharts := map[string]*chart.Chart{}
+	if len(segments) == 0 {
+		for _, sub := range chrt.Dependencies() {
+			subcharts[sub.Name()] = sub
+		}
+		// The values of an aliased dependency are under its alias, a chart
+		// may also be a dependency several times with different aliases.
+		aliased := map[string]*chart.Chart{}
+		for _, dep := range chrt.Metadata.Dependencies {
+			sub, ok := subcharts[dep.Name]
+			if !ok {
+				continue
+			}
+			if dep.Alias != "" {
+				aliased[dep.Alias] = sub
+			} else {
+				aliased[dep.Name] = sub
+			}
+		}
+		for _, dep := range chrt.Metadata.Dependencies {
+			if dep.Alias != "" {
+				delete(subcharts, dep.Name)
+			}
+		}
+		for k, sub := range aliased {
+			subcharts[k] = sub
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var fieldErrors []ValuesFieldError
+	for _, k := range keys {
+		v := values[k]
+		path := appendSegment(segments, k)
+		vMap, isMap := v.(map[string]interface{})
+
+		if sub, ok := subcharts[k]; ok {
+			if isMap {
+				fieldErrors = append(fieldErrors, unknownValueKeys(sub, sub.Values, vMap, nil)...)
+			}
+			continue
+		}
+		if len(segments) == 0 && k == chartutil.GlobalKey {
+			continue
+		}
+
+		dv, ok := defaults[k]
+		if !ok {
+			fieldErrors = append(fieldErrors, ValuesFieldError{
+				Chart:    chrt.Name(),
+				Path:     formatFieldPath(path),
+				Reason:   unknownValueKey,
+				Message:  fmt.Sprintf("'%s' is not a value of chart '%s'", k, chrt.Name()),
+				Severity: ValuesErrorSeverity.warning,
+			})
+			continue
+		}
+
+		if dvMap, ok := dv.(map[string]interface{}); ok && isMap {
+			fieldErrors = append(fieldErrors, unknownValueKeys(chrt, dvMap, vMap, path)...)
+		}
+	}
+
+	return fieldErrors
+}
+
+// ValidateValues checks the user values against values.schema.json of the
+// chart and its subcharts, the way helm does on install, and warns about keys
+// the chart does not define.
+func (h *Helm) ValidateValues(config *rest.Config, opts HelmOptions, values map[string]interface{}) (vv ValuesValidation, err error) {
+	chrt, err := h.loadChart(config, opts)
+	if err != nil {
+		return vv, err
+	}
+
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+
+	vv.Errors = unknownValueKeys(chrt, chrt.Values, values, nil)
+
+	if err := chartutil.ProcessDependenciesWithMerge(chrt, values); err != nil {
+		return vv, err
+	}
+
+	coalesced, err := chartutil.CoalesceValues(chrt, values)
+	if err != nil {
+		return vv, err
+	}
+
+	schemaErrors, err := validateSchema(chrt, coalesced, nil)
+	if err != nil {
+		return vv, err
+	}
+	vv.Errors = append(schemaErrors, vv.Errors...)
+
+	vv.HasSchema = len(chrt.Schema) > 0
+	vv.Valid = true
+	for _, fe := range vv.Errors {
+		if fe.Severity == ValuesErrorSeverity.error {
+			vv.Valid = false
+		}
+	}
+	if vv.Errors == nil {
+		vv.Errors = []ValuesFieldError{}
+	}
+
+	return vv, nil
+}
+
+// DiffValues compares the user values with the chart defaults and, when the
+// release is installed, with the values it was deployed with.
+func (h *Helm) DiffValues(config *rest.Config, opts HelmOptions, values map[string]interface{}) (vd ValuesDiff, err error) {
+	chrt, err := h.loadChart(config, opts)
+	if err != nil {
+		return vd, err
+	}
+
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	vd.User = values
+
+	// Both sides are coalesced, so the subchart defaults and globals the
+	// coalescing adds don't show up as overrides.
+	defaults, err := chartutil.CoalesceValues(chrt, nil)
+	if err != nil {
+		return vd, err
+	}
+	vd.Defaults = defaults
+
+	coalesced, err := chartutil.CoalesceValues(chrt, values)
+	if err != nil {
+		return vd, err
+	}
+	vd.Overrides = diffValues(nil, map[string]interface{}(defaults), map[string]interface{}(coalesced))
+
+	vd.Deployed = map[string]interface{}{}
+	if h.ReleaseName != "" {
+		rl, err := h.GetRelease(config)
+		if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+			return vd, err
+		}
+		if rl != nil && rl.Config != nil {
+			vd.Deployed = rl.Config
+		}
+	}
+	vd.Changes = diffValues(nil, vd.Deployed, values)
+
+	for _, changes := range [][]FieldChange{vd.Overrides, vd.Changes} {
+		for i := range changes {
+			changes[i].Path = formatFieldPath(changes[i].segments)
+		}
+	}
+	if vd.Overrides == nil {
+		vd.Overrides = []FieldChange{}
+	}
+	if vd.Changes == nil {
+		vd.Changes = []FieldChange{}
+	}
+
+	deployedYAML, err := yaml.Marshal(vd.Deployed)
+	if err != nil {
+		return vd, err
+	}
+	userYAML, err := yaml.Marshal(values)
+	if err != nil {
+		return vd, err
+	}
+	vd.Unified = unifiedDiff("deployed", "user", string(deployedYAML), string(userYAML))
+
+	return vd, nil
+}
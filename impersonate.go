@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	errUntrustedProxy        = errors.New("request did not come from a trusted proxy")
+	errMissingProxyIdentity  = errors.New("trusted proxy did not provide a user identity")
+	defaultUserHeader        = "X-Forwarded-User"
+	defaultGroupsHeader      = "X-Forwarded-Groups"
+	defaultTrustedProxyCIDRs = []string{"127.0.0.1/32", "::1/128"}
+)
+
+type Impersonation struct {
+	Enabled        bool
+	UserHeader     string
+	GroupsHeader   string
+	TrustedProxies []*net.IPNet
+}
+
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address '%s'", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy CIDR '%s': %w", proxy, err)
+		}
+		nets = append(nets, ipNet)
+	}
+
+	return nets, nil
+}
+
+func (imp *Impersonation) trusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range imp.TrustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Identity reads the user and groups set by the authenticating proxy. The
+// headers are only honored when the connection itself comes from a trusted
+// proxy address, X-Forwarded-For is deliberately not taken into account.
+func (imp *Impersonation) Identity(c echo.Context) (*rest.ImpersonationConfig, error) {
+	if !imp.trusted(c.Request().RemoteAddr) {
+		return nil, errUntrustedProxy
+	}
+
+	user := strings.TrimSpace(c.Request().Header.Get(imp.UserHeader))
+	if user == "" {
+		return nil, errMissingProxyIdentity
+	}
+
+	var groups []string
+	for _, value := range c.Request().Header.Values(imp.GroupsHeader) {
+		for _, group := range strings.Split(value, ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				groups = append(groups, group)
+			}
+		}
+	}
+	slices.Sort(groups)
+
+	return &rest.ImpersonationConfig{UserName: user, Groups: slices.Compact(groups)}, nil
+}
+
+func sameIdentity(a, b *rest.ImpersonationConfig) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.UserName == b.UserName && slices.Equal(a.Groups, b.Groups)
+}
+
+func impersonateAPIResource(ar *APIResource, identity *rest.ImpersonationConfig) (*APIResource, error) {
+	arCopy, err := copyAPIResource(ar)
+	if err != nil || arCopy.Config == nil {
+		return arCopy, err
+	}
+
+	arCopy.Config.Impersonate = *identity
+
+	ssar, err := initClients(arCopy)
+	if err != nil {
+		return nil, fmt.Errorf("could not impersonate '%s': %w", identity.UserName, err)
+	}
+	arCopy.SSAR = ssar
+
+	return arCopy, nil
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"fmt"
+	"sort"
 
 	authv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
@@ -17,13 +20,8 @@ func initClusterConfig(authReq *AuthRequest) (config *rest.Config, err error) {
 		if err != nil {
 			return nil, err
 		}
-	case KubernetesConfigType.kubeconfigPath:
-		config, err = clientcmd.BuildConfigFromFlags("", authReq.KubeconfigPath)
-		if err != nil {
-			return nil, err
-		}
-	case KubernetesConfigType.kubeconfigRaw:
-		cc, errcc := clientcmd.NewClientConfigFromBytes([]byte(authReq.KubeconfigRaw))
+	case KubernetesConfigType.kubeconfigPath, KubernetesConfigType.kubeconfigRaw:
+		cc, errcc := kubeClientConfig(authReq)
 		if errcc != nil {
 			return nil, errcc
 		}
@@ -46,6 +44,78 @@ func initClusterConfig(authReq *AuthRequest) (config *rest.Config, err error) {
 	return config, nil
 }
 
+func kubeClientConfig(authReq *AuthRequest) (clientcmd.ClientConfig, error) {
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: authReq.Context}
+
+	switch authReq.Type {
+	case KubernetesConfigType.kubeconfigPath:
+		return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: authReq.KubeconfigPath}, overrides), nil
+	case KubernetesConfigType.kubeconfigRaw:
+		rawConfig, err := clientcmd.Load([]byte(authReq.KubeconfigRaw))
+		if err != nil {
+			return nil, err
+		}
+
+		return clientcmd.NewNonInteractiveClientConfig(*rawConfig, authReq.Context, overrides, nil), nil
+	}
+
+	return nil, fmt.Errorf("auth type '%s' does not use a kubeconfig", authReq.Type)
+}
+
+type KubeconfigContext struct {
+	Name      string `json:"name"`
+	Cluster   string `json:"cluster"`
+	User      string `json:"user"`
+	Namespace string `json:"namespace"`
+}
+
+type KubeconfigCluster struct {
+	Name   string `json:"name"`
+	Server string `json:"server"`
+}
+
+type KubeconfigContexts struct {
+	CurrentContext string              `json:"currentContext"`
+	Contexts       []KubeconfigContext `json:"contexts"`
+	Clusters       []KubeconfigCluster `json:"clusters"`
+	Users          []string            `json:"users"`
+}
+
+func listKubeconfigContexts(authReq *AuthRequest) (kc KubeconfigContexts, err error) {
+	cc, err := kubeClientConfig(authReq)
+	if err != nil {
+		return kc, err
+	}
+
+	rawConfig, err := cc.RawConfig()
+	if err != nil {
+		return kc, err
+	}
+
+	kc.CurrentContext = rawConfig.CurrentContext
+	for name, ctx := range rawConfig.Contexts {
+		kc.Contexts = append(kc.Contexts, KubeconfigContext{
+			Name:      name,
+			Cluster:   ctx.Cluster,
+			User:      ctx.AuthInfo,
+			Namespace: ctx.Namespace,
+		})
+	}
+	for name, cluster := range rawConfig.Clusters {
+		kc.Clusters = append(kc.Clusters, KubeconfigCluster{Name: name, Server: cluster.Server})
+	}
+	for name := range rawConfig.AuthInfos {
+		kc.Users = append(kc.Users, name)
+	}
+
+	sort.Slice(kc.Contexts, func(i, j int) bool { return kc.Contexts[i].Name < kc.Contexts[j].Name })
+	sort.Slice(kc.Clusters, func(i, j int) bool { return kc.Clusters[i].Name < kc.Clusters[j].Name })
+	sort.Strings(kc.Users)
+
+	return kc, nil
+}
+
 func initClientset(config *rest.Config) (*kubernetes.Clientset, error) {
 	return kubernetes.NewForConfig(config)
 }
@@ -61,6 +131,24 @@ func authInit(ar *APIResource) (ssar *authv1.SelfSubjectAccessReview, err error)
 	}
 	ar.Config = cfg
 
+	if ar.AuthRequest.Context == "" && (ar.AuthRequest.Type == KubernetesConfigType.kubeconfigPath ||
+		ar.AuthRequest.Type == KubernetesConfigType.kubeconfigRaw) {
+		cc, err := kubeClientConfig(ar.AuthRequest)
+		if err != nil {
+			return ssar, fmt.Errorf("could not load kubeconfig: %s", err)
+		}
+
+		rawConfig, err := cc.RawConfig()
+		if err != nil {
+			return ssar, fmt.Errorf("could not load kubeconfig: %s", err)
+		}
+		ar.AuthRequest.Context = rawConfig.CurrentContext
+	}
+
+	return initClients(ar)
+}
+
+func initClients(ar *APIResource) (ssar *authv1.SelfSubjectAccessReview, err error) {
 	cs, err := initClientset(ar.Config)
 	if err != nil {
 		return ssar, fmt.Errorf("could not initialize kubernetes clientset: %s", err)
@@ -72,6 +160,7 @@ func authInit(ar *APIResource) (ssar *authv1.SelfSubjectAccessReview, err error)
 		return ssar, fmt.Errorf("could not initialize kubernetes dynamic client: %s", err)
 	}
 	ar.DynamicClient = dc
+	ar.RESTMapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(cs.Discovery()))
 
 	hac, hes, err := initHelm(ar)
 	if err != nil {
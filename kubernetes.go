@@ -1,12 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
 
 	authv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
@@ -23,6 +32,18 @@ func (cd *CreateResource) Create(client *dynamic.DynamicClient) (*unstructured.U
 	return client.Resource(cd.GVR).Namespace(cd.Namespace).Create(context.TODO(), cd.Object, cd.Options)
 }
 
+type ApplyResource struct {
+	Name      string
+	Namespace string
+	Options   metav1.ApplyOptions
+	GVR       schema.GroupVersionResource
+	Object    *unstructured.Unstructured
+}
+
+func (ar *ApplyResource) Apply(client *dynamic.DynamicClient) (*unstructured.Unstructured, error) {
+	return client.Resource(ar.GVR).Namespace(ar.Namespace).Apply(context.TODO(), ar.Name, ar.Object, ar.Options)
+}
+
 type ListResource struct {
 	Namespace string
 	Options   metav1.ListOptions
@@ -33,8 +54,8 @@ func (ld *ListResource) List(client *dynamic.DynamicClient) (*unstructured.Unstr
 	return client.Resource(ld.GVR).Namespace(ld.Namespace).List(context.TODO(), ld.Options)
 }
 
-func (ld *ListResource) Watch(client *dynamic.DynamicClient) (watch.Interface, error) {
-	return client.Resource(ld.GVR).Namespace(ld.Namespace).Watch(context.TODO(), ld.Options)
+func (ld *ListResource) Watch(ctx context.Context, client *dynamic.DynamicClient) (watch.Interface, error) {
+	return client.Resource(ld.GVR).Namespace(ld.Namespace).Watch(ctx, ld.Options)
 }
 
 type GetResource struct {
@@ -50,20 +71,20 @@ func (gr *GetResource) Get(client *dynamic.DynamicClient) (*unstructured.Unstruc
 		return nil, err
 	}
 
-	unstructured.RemoveNestedField(resource.Object, "metadata", "resourceVersion")
 	unstructured.RemoveNestedField(resource.Object, "metadata", "managedFields")
 
 	return resource, nil
 }
 
 type UpdateResource struct {
-	Name          string
-	Namespace     string
-	Data          []byte
-	UpdateOptions metav1.UpdateOptions
-	GetOptions    metav1.GetOptions
-	GVR           schema.GroupVersionResource
-	GVK           *schema.GroupVersionKind
+	Name            string
+	Namespace       string
+	Data            []byte
+	ResourceVersion string
+	UpdateOptions   metav1.UpdateOptions
+	GetOptions      metav1.GetOptions
+	GVR             schema.GroupVersionResource
+	GVK             *schema.GroupVersionKind
 }
 
 func (ur *UpdateResource) Update(client *dynamic.DynamicClient) (*unstructured.Unstructured, error) {
@@ -73,17 +94,48 @@ func (ur *UpdateResource) Update(client *dynamic.DynamicClient) (*unstructured.U
 		return nil, err
 	}
 
+	if ur.ResourceVersion != "" {
+		obj.SetResourceVersion(ur.ResourceVersion)
+	}
+
 	resource, err := client.Resource(ur.GVR).Namespace(ur.Namespace).Update(context.TODO(), obj, ur.UpdateOptions)
 	if err != nil {
 		return nil, err
 	}
 
-	unstructured.RemoveNestedField(resource.Object, "metadata", "resourceVersion")
 	unstructured.RemoveNestedField(resource.Object, "metadata", "managedFields")
 
 	return resource, nil
 }
 
+type PatchResource struct {
+	Name         string
+	Namespace    string
+	PatchType    types.PatchType
+	Data         []byte
+	Options      metav1.PatchOptions
+	Subresources []string
+	GVR          schema.GroupVersionResource
+}
+
+func (pr *PatchResource) Patch(client *dynamic.DynamicClient) (*unstructured.Unstructured, error) {
+	resource, err := client.Resource(pr.GVR).Namespace(pr.Namespace).Patch(context.TODO(), pr.Name, pr.PatchType, pr.Data, pr.Options, pr.Subresources...)
+	if err != nil {
+		return nil, err
+	}
+
+	unstructured.RemoveNestedField(resource.Object, "metadata", "managedFields")
+
+	return resource, nil
+}
+
+var patchTypes = map[string]types.PatchType{
+	"json":      types.JSONPatchType,
+	"merge":     types.MergePatchType,
+	"strategic": types.StrategicMergePatchType,
+	"apply":     types.ApplyPatchType,
+}
+
 type DeleteResource struct {
 	Name      string
 	Namespace string
@@ -108,3 +160,75 @@ func (ssa *SelfSubjectAuth) AccessReview(client kubernetes.Interface) (*authv1.S
 func (ssa *SelfSubjectAuth) RulesReview(client kubernetes.Interface) (*authv1.SelfSubjectRulesReview, error) {
 	return client.AuthorizationV1().SelfSubjectRulesReviews().Create(context.TODO(), ssa.Rules, ssa.Options)
 }
+
+// decodeManifests reads one or more YAML or JSON documents, List kinds are
+// expanded into their items. Errors name the document, counted from 1, and
+// the item of a List.
+func decodeManifests(data []byte) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for doc := 1; ; doc++ {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("manifest document %d: %w", doc, err)
+		}
+
+		if len(obj.Object) == 0 {
+			continue
+		}
+
+		if obj.IsList() {
+			list, err := obj.ToList()
+			if err != nil {
+				return nil, fmt.Errorf("manifest document %d: %w", doc, err)
+			}
+			for i := range list.Items {
+				item := &list.Items[i]
+				if item.IsList() {
+					return nil, fmt.Errorf("manifest document %d item %d is a nested list", doc, i+1)
+				}
+				if err := validateManifest(item); err != nil {
+					return nil, fmt.Errorf("manifest document %d item %d %w", doc, i+1, err)
+				}
+				objs = append(objs, item)
+			}
+			continue
+		}
+
+		if err := validateManifest(obj); err != nil {
+			return nil, fmt.Errorf("manifest document %d %w", doc, err)
+		}
+		objs = append(objs, obj)
+	}
+
+	return objs, nil
+}
+
+func validateManifest(obj *unstructured.Unstructured) error {
+	if obj.GetKind() == "" || obj.GetAPIVersion() == "" {
+		return errors.New("is missing apiVersion or kind")
+	}
+	if _, err := schema.ParseGroupVersion(obj.GetAPIVersion()); err != nil {
+		return fmt.Errorf("has an invalid apiVersion: %w", err)
+	}
+	if ns := obj.GetNamespace(); ns != "" {
+		if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+			return fmt.Errorf("has an invalid namespace '%s': %s", ns, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+func restMapping(mapper meta.ResettableRESTMapper, gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// The kind might have been registered after the discovery cache was filled.
+		mapper.Reset()
+		mapping, err = mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	return mapping, err
+}
@@ -1,24 +1,37 @@
 package main
 
 import (
-	"bufio"
-	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 )
 
 type LogStreamSession struct {
-	id    string
-	bound chan error
-	ws    *websocket.Conn
-	close chan struct{}
+	id     string
+	bound  chan error
+	ws     *websocket.Conn
+	close  chan struct{}
+	lock   *sync.Mutex
+	filter *logFilterState
+	stream *logStream
+}
+
+func (lss LogStreamSession) WriteJSON(v interface{}) error {
+	lss.lock.Lock()
+	defer lss.lock.Unlock()
+	return lss.ws.WriteJSON(v)
 }
 
 type LogStreamMessage struct {
@@ -27,6 +40,10 @@ type LogStreamMessage struct {
 	SessionID  string `json:"sessionId"`
 	Error      string `json:"error"`
 	StatusCode uint   `json:"statusCode"`
+	// Lines are the lines of a batch and Dropped the number of lines dropped
+	// before it because the client could not keep up.
+	Lines   []LogLine `json:"lines,omitempty"`
+	Dropped int       `json:"dropped,omitempty"`
 }
 
 type LogStreamSessionMap struct {
@@ -50,7 +67,7 @@ func (lssm *LogStreamSessionMap) Close(sessionId string, status uint, reason str
 	lssm.Lock.Lock()
 	defer lssm.Lock.Unlock()
 	ses := lssm.Sessions[sessionId]
-	ses.ws.WriteJSON(LogStreamMessage{Op: "close", Data: reason, StatusCode: status})
+	ses.WriteJSON(LogStreamMessage{Op: "close", Data: reason, StatusCode: status})
 	ses.ws.Close()
 	delete(lssm.Sessions, sessionId)
 }
@@ -87,70 +104,162 @@ func handleLogStreamSession(ws *websocket.Conn) {
 		SessionID: lsm.SessionID,
 	}
 
-	if senderr := ws.WriteJSON(sendMsg); senderr != nil {
+	if senderr := lss.WriteJSON(sendMsg); senderr != nil {
 		fmt.Println("handleStreamData senderr:", senderr)
 		return
 	}
 }
 
 func (lss LogStreamSession) SendLogs(client kubernetes.Interface, pld *PodLogsData) error {
+	opts := pld.Options.DeepCopy()
+	opts.Timestamps = true
+
 	req := client.CoreV1().RESTClient().Get().
 		Namespace(pld.Namespace).
 		Name(pld.Name).
 		Resource(pld.ResourceType).
 		SubResource("log").
-		Param("follow", pld.Follow).
-		Param("container", pld.ContainerName)
-
-	if pld.TailLines != "" {
-		req.Param("tailLines", pld.TailLines)
-	}
+		VersionedParams(opts, pld.ParameterCodec)
 
 	go func() {
-		out, err := req.Stream(context.TODO())
+		out, err := req.Stream(lss.stream.ctx)
 		if err != nil {
+			// Like a missing previous container, the client needs to know
+			// why there are no logs.
+			lss.stream.Finish(WSCloseCode.error, err.Error())
 			return
 		}
 		defer out.Close()
 
-		reader := bufio.NewReader(out)
-		for {
-			line, err := reader.ReadBytes('\n')
-			if err != nil {
-				if err == io.EOF {
-					lss.ws.WriteJSON(LogStreamMessage{
-						Op:         "close",
-						Data:       "Log stream ended",
-						StatusCode: WSCloseCode.info,
-					})
-					lss.ws.Close()
-				}
-				return
-			}
-			lsm := LogStreamMessage{
-				Op:        WSOpType.stdout,
-				SessionID: lss.id,
-				Data:      string(line),
-			}
-
-			if err := lss.ws.WriteJSON(lsm); err != nil {
-				return
-			}
+		err = scanLogLines(out, opts.Timestamps, func(line LogLine) error {
+			lss.stream.Push(line)
+			return nil
+		})
+		if err != nil {
+			lss.stream.Finish(WSCloseCode.error, err.Error())
+			return
 		}
+		lss.stream.Finish(WSCloseCode.info, "Log stream ended")
 	}()
 
 	return nil
 }
 
+// StartLogs starts the stream of the session, further requests to start it
+// are ignored.
+func (lss LogStreamSession) StartLogs(client kubernetes.Interface, pld *PodLogsData) error {
+	if !lss.stream.Start(lss) {
+		return nil
+	}
+
+	if pld.multiPod() {
+		return lss.SendPodsLogs(client, pld)
+	}
+	return lss.SendLogs(client, pld)
+}
+
+// splitLogTimestamp splits the RFC3339 timestamp the API server puts in front
+// of every line when timestamps are requested.
+func splitLogTimestamp(line string, timestamps bool) (*time.Time, string) {
+	if !timestamps {
+		return nil, line
+	}
+
+	ts, rest, found := strings.Cut(line, " ")
+	if !found {
+		return nil, line
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return nil, line
+	}
+
+	return &t, rest
+}
+
 type PodLogsData struct {
-	Namespace      string
-	Name           string
-	ResourceType   string
-	ContainerName  string
-	Follow         string
-	TailLines      string
+	Namespace    string
+	Name         string
+	ResourceType string
+	// Kind and LabelSelector select the pods of a multi-pod log session,
+	// Name is the name of the workload then.
+	Kind           string
+	LabelSelector  string
 	Options        *corev1.PodLogOptions
 	ParameterCodec runtime.ParameterCodec
+	Filter         *logFilter
+	// ResumeFrom is the timestamp of the last line a reconnecting client saw.
+	ResumeFrom *time.Time
+}
+
+// parsePodLogOptions reads the log options of the query, named like the
+// parameters of the pod log API.
+func parsePodLogOptions(query url.Values) (*corev1.PodLogOptions, error) {
+	opts := &corev1.PodLogOptions{Container: query.Get("container")}
+
+	for name, dest := range map[string]*bool{
+		"follow":     &opts.Follow,
+		"previous":   &opts.Previous,
+		"timestamps": &opts.Timestamps,
+	} {
+		if v := query.Get(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid '%s' value '%s'", name, v)
+			}
+			*dest = b
+		}
+	}
+
+	for name, dest := range map[string]**int64{
+		"tailLines":    &opts.TailLines,
+		"sinceSeconds": &opts.SinceSeconds,
+		"limitBytes":   &opts.LimitBytes,
+	} {
+		if v := query.Get(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 || (n == 0 && name != "tailLines") {
+				return nil, fmt.Errorf("invalid '%s' value '%s'", name, v)
+			}
+			*dest = &n
+		}
+	}
+
+	if v := query.Get("sinceTime"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'sinceTime' value '%s', expected RFC3339", v)
+		}
+		opts.SinceTime = &metav1.Time{Time: t}
+	}
+
+	if opts.SinceSeconds != nil && opts.SinceTime != nil {
+		return nil, errors.New("only one of 'sinceSeconds' and 'sinceTime' can be set")
+	}
+
+	return opts, nil
+}
+
+// newLogSession registers a log session for the pod, the client binds to it
+// over the logs websocket.
+func newLogSession(client kubernetes.Interface, pld *PodLogsData) (string, error) {
+	sessionID, err := genSessionId()
+	if err != nil {
+		return "", err
+	}
+
+	logStreamSessions.Set(sessionID, LogStreamSession{
+		id:     sessionID,
+		bound:  make(chan error),
+		close:  make(chan struct{}),
+		lock:   &sync.Mutex{},
+		filter: &logFilterState{filter: pld.Filter},
+		stream: newLogStream(pld.ResumeFrom, pld.Options.Timestamps),
+	})
+	go pld.WaitForLogs(client, sessionID)
+
+	return sessionID, nil
 }
 
 func startLogsStream(client kubernetes.Interface, lss LogStreamSession, closeChan chan struct{}, pld *PodLogsData) error {
@@ -168,7 +277,11 @@ func startLogsStream(client kubernetes.Interface, lss LogStreamSession, closeCha
 
 		switch {
 		case lsm.Op == WSOpType.stdin && lss.id == lsm.SessionID:
-			if err := lss.SendLogs(client, pld); err != nil {
+			if err := lss.StartLogs(client, pld); err != nil {
+				return err
+			}
+		case lsm.Op == WSOpType.logFilter && lss.id == lsm.SessionID:
+			if err := lss.SetFilter(lsm); err != nil {
 				return err
 			}
 		case lsm.Op == WSOpType.close && lss.id == lsm.SessionID:
@@ -178,7 +291,34 @@ func startLogsStream(client kubernetes.Interface, lss LogStreamSession, closeCha
 	}
 }
 
+// SetFilter replaces the filter of the running streams, the reply carries
+// the error of an invalid filter.
+func (lss LogStreamSession) SetFilter(recvMsg LogStreamMessage) error {
+	lsm := LogStreamMessage{
+		Op:        WSOpType.logFilter,
+		SessionID: lss.id,
+		Data:      recvMsg.Data,
+	}
+
+	var lf LogFilter
+	if err := json.Unmarshal([]byte(recvMsg.Data), &lf); err != nil {
+		lsm.Error = err.Error()
+		return lss.WriteJSON(lsm)
+	}
+
+	f, err := compileLogFilter(lf)
+	if err != nil {
+		lsm.Error = err.Error()
+		return lss.WriteJSON(lsm)
+	}
+	lss.filter.Set(f)
+
+	return lss.WriteJSON(lsm)
+}
+
 func (pld *PodLogsData) WaitForLogs(client kubernetes.Interface, sessionId string) {
+	defer logStreamSessions.Get(sessionId).stream.Stop()
+
 	select {
 	case <-logStreamSessions.Get(sessionId).bound:
 		close(logStreamSessions.Get(sessionId).bound)
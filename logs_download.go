@@ -0,0 +1,277 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var LogExportFormat = struct {
+	text,
+	ndjson,
+	tar string
+}{
+	text:   "text",
+	ndjson: "ndjson",
+	tar:    "tar",
+}
+
+var logExportExtensions = map[string]string{
+	LogExportFormat.text:   ".log",
+	LogExportFormat.ndjson: ".ndjson",
+	LogExportFormat.tar:    ".tar.gz",
+}
+
+var logExportContentTypes = map[string]string{
+	LogExportFormat.text:   "text/plain; charset=utf-8",
+	LogExportFormat.ndjson: "application/x-ndjson",
+	LogExportFormat.tar:    "application/gzip",
+}
+
+type logSource struct {
+	Pod       string
+	Container string
+}
+
+// LogExportLine is a line of an NDJSON log export.
+type LogExportLine struct {
+	Pod       string                 `json:"pod"`
+	Container string                 `json:"container"`
+	Timestamp *time.Time             `json:"timestamp,omitempty"`
+	Level     string                 `json:"level,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	Message   string                 `json:"message"`
+	Error     string                 `json:"error,omitempty"`
+}
+
+// containerHasLogs reports whether the container ran, for previous logs it
+// has to have been terminated before.
+func containerHasLogs(cs corev1.ContainerStatus, previous bool) bool {
+	if previous {
+		return cs.LastTerminationState.Terminated != nil
+	}
+	return cs.State.Running != nil || cs.State.Terminated != nil
+}
+
+// logSources lists the containers to export, the single container of the
+// options or every container with logs of the pod or the workload.
+func (pld *PodLogsData) logSources(ctx context.Context, client kubernetes.Interface) ([]logSource, error) {
+	var pods []corev1.Pod
+	if pld.multiPod() {
+		selector, err := pld.podSelector(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+
+		pl, err := client.CoreV1().Pods(pld.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return nil, err
+		}
+		pods = pl.Items
+	} else {
+		if pld.Options.Container != "" {
+			return []logSource{{Pod: pld.Name, Container: pld.Options.Container}}, nil
+		}
+
+		pod, err := client.CoreV1().Pods(pld.Namespace).Get(ctx, pld.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		pods = []corev1.Pod{*pod}
+	}
+
+	sources := []logSource{}
+	for _, pod := range pods {
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, cs := range statuses {
+			if pld.Options.Container != "" && cs.Name != pld.Options.Container {
+				continue
+			}
+			if containerHasLogs(cs, pld.Options.Previous) {
+				sources = append(sources, logSource{Pod: pod.Name, Container: cs.Name})
+			}
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no containers with logs found in namespace '%s'", pld.Namespace)
+	}
+
+	return sources, nil
+}
+
+// readLogs calls fn for every line of the logs of the container that passes
+// the filter. Exports never follow the logs.
+func (pld *PodLogsData) readLogs(ctx context.Context, client kubernetes.Interface, src logSource, fn func(line LogLine) error) error {
+	opts := pld.Options.DeepCopy()
+	opts.Container = src.Container
+	opts.Follow = false
+
+	out, err := client.CoreV1().RESTClient().Get().
+		Namespace(pld.Namespace).
+		Name(src.Pod).
+		Resource("pods").
+		SubResource("log").
+		VersionedParams(opts, pld.ParameterCodec).
+		Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return scanLogLines(out, opts.Timestamps, func(line LogLine) error {
+		line.Pod, line.Container = src.Pod, src.Container
+		if !pld.Filter.Apply(&line) {
+			return nil
+		}
+		return fn(line)
+	})
+}
+
+// withNewline terminates the last line of logs cut by limitBytes.
+func withNewline(line string) string {
+	if len(line) > 0 && line[len(line)-1] != '\n' {
+		return line + "\n"
+	}
+	return line
+}
+
+func exportLogs(ctx context.Context, w http.ResponseWriter, client kubernetes.Interface, pld *PodLogsData, sources []logSource, format string) error {
+	switch format {
+	case LogExportFormat.ndjson:
+		return exportLogsNDJSON(ctx, w, client, pld, sources)
+	case LogExportFormat.tar:
+		return exportLogsTar(ctx, w, client, pld, sources)
+	}
+	return exportLogsText(ctx, w, client, pld, sources)
+}
+
+// exportLogsText writes the logs container after container, the lines are
+// prefixed with the container when there is more than one.
+func exportLogsText(ctx context.Context, w http.ResponseWriter, client kubernetes.Interface, pld *PodLogsData, sources []logSource) error {
+	for _, src := range sources {
+		prefix := ""
+		if len(sources) > 1 {
+			prefix = fmt.Sprintf("[%s/%s] ", src.Pod, src.Container)
+		}
+
+		err := pld.readLogs(ctx, client, src, func(line LogLine) error {
+			text := line.Data
+			if line.Timestamp != nil {
+				text = line.Timestamp.Format(time.RFC3339Nano) + " " + text
+			}
+			_, err := io.WriteString(w, prefix+withNewline(text))
+			return err
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%serror reading logs: %s\n", prefix, err)
+		}
+		w.(http.Flusher).Flush()
+	}
+
+	return nil
+}
+
+func exportLogsNDJSON(ctx context.Context, w http.ResponseWriter, client kubernetes.Interface, pld *PodLogsData, sources []logSource) error {
+	enc := json.NewEncoder(w)
+	for _, src := range sources {
+		err := pld.readLogs(ctx, client, src, func(line LogLine) error {
+			return enc.Encode(LogExportLine{
+				Pod:       line.Pod,
+				Container: line.Container,
+				Timestamp: line.Timestamp,
+				Level:     line.Level,
+				Fields:    line.Fields,
+				Message:   strings.TrimRight(line.Data, "\r\n"),
+			})
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			enc.Encode(LogExportLine{Pod: src.Pod, Container: src.Container, Error: err.Error()})
+		}
+		w.(http.Flusher).Flush()
+	}
+
+	return nil
+}
+
+// exportLogsTar writes one <pod>/<container>.log file per container. Tar
+// headers need the size up front, so every container is buffered in a
+// temporary file first.
+func exportLogsTar(ctx context.Context, w http.ResponseWriter, client kubernetes.Interface, pld *PodLogsData, sources []logSource) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	for _, src := range sources {
+		if err := exportLogsTarFile(ctx, tw, client, pld, src); err != nil {
+			return err
+		}
+		w.(http.Flusher).Flush()
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func exportLogsTarFile(ctx context.Context, tw *tar.Writer, client kubernetes.Interface, pld *PodLogsData, src logSource) error {
+	tmp, err := os.CreateTemp("", "lutho-logs-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	errRead := pld.readLogs(ctx, client, src, func(line LogLine) error {
+		text := line.Data
+		if line.Timestamp != nil {
+			text = line.Timestamp.Format(time.RFC3339Nano) + " " + text
+		}
+		_, err := io.WriteString(tmp, withNewline(text))
+		return err
+	})
+	if errRead != nil {
+		if ctx.Err() != nil {
+			return errRead
+		}
+		fmt.Fprintf(tmp, "error reading logs: %s\n", errRead)
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	hdr := &tar.Header{
+		Name:    path.Join(src.Pod, src.Container+".log"),
+		Mode:    0o644,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, tmp)
+	return err
+}
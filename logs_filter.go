@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// LogFilter is set with the query of the log stream and changed with the
+// logFilter op. Lines without a detected level pass the level filter, they
+// are mostly the continuation of a multi-line entry.
+type LogFilter struct {
+	Include  string `json:"include"`
+	Exclude  string `json:"exclude"`
+	MinLevel string `json:"minLevel"`
+}
+
+var logLevelRanks = map[string]int{
+	"trace": 0,
+	"debug": 1,
+	"info":  2,
+	"warn":  3,
+	"error": 4,
+	"fatal": 5,
+}
+
+var logLevelAliases = map[string]string{
+	"trc":      "trace",
+	"dbg":      "debug",
+	"inf":      "info",
+	"notice":   "info",
+	"warning":  "warn",
+	"wrn":      "warn",
+	"err":      "error",
+	"eror":     "error",
+	"crit":     "fatal",
+	"critical": "fatal",
+	"panic":    "fatal",
+	"dpanic":   "fatal",
+}
+
+var (
+	logLevelKeys    = []string{"level", "lvl", "severity", "loglevel", "log.level"}
+	textLevelRegexp = regexp.MustCompile(`\b(TRACE|DEBUG|INFO|NOTICE|WARN|WARNING|ERROR|FATAL|PANIC|CRITICAL)\b`)
+	klogLevelRegexp = regexp.MustCompile(`^([IWEF])\d{4} \d{2}:\d{2}:\d{2}`)
+	klogLevels      = map[string]string{"I": "info", "W": "warn", "E": "error", "F": "fatal"}
+)
+
+func normalizeLogLevel(level string) string {
+	level = strings.ToLower(strings.TrimSpace(level))
+	if alias, ok := logLevelAliases[level]; ok {
+		return alias
+	}
+	if _, ok := logLevelRanks[level]; ok {
+		return level
+	}
+	return ""
+}
+
+type logFilter struct {
+	include  *regexp.Regexp
+	exclude  *regexp.Regexp
+	minLevel string
+}
+
+func compileLogFilter(lf LogFilter) (*logFilter, error) {
+	f := &logFilter{}
+
+	var err error
+	if lf.Include != "" {
+		if f.include, err = regexp.Compile(lf.Include); err != nil {
+			return nil, fmt.Errorf("invalid include filter: %w", err)
+		}
+	}
+	if lf.Exclude != "" {
+		if f.exclude, err = regexp.Compile(lf.Exclude); err != nil {
+			return nil, fmt.Errorf("invalid exclude filter: %w", err)
+		}
+	}
+	if lf.MinLevel != "" {
+		if f.minLevel = normalizeLogLevel(lf.MinLevel); f.minLevel == "" {
+			return nil, fmt.Errorf("unknown log level '%s'", lf.MinLevel)
+		}
+	}
+
+	return f, nil
+}
+
+// logFilterState holds the filter of a log session, the read loop of the
+// session replaces it while the streams apply it.
+type logFilterState struct {
+	filter *logFilter
+	lock   sync.RWMutex
+}
+
+func (lfs *logFilterState) Get() *logFilter {
+	lfs.lock.RLock()
+	defer lfs.lock.RUnlock()
+	return lfs.filter
+}
+
+func (lfs *logFilterState) Set(f *logFilter) {
+	lfs.lock.Lock()
+	defer lfs.lock.Unlock()
+	lfs.filter = f
+}
+
+// parseLogfmt parses lines like 'level=info msg="request done" took=3ms'.
+// Every word has to be a key=value pair and at least two pairs are needed,
+// so ordinary text with a single '=' is not taken for logfmt.
+func parseLogfmt(line string) (map[string]interface{}, bool) {
+	fields := map[string]interface{}{}
+
+	rest := strings.TrimSpace(line)
+	for rest != "" {
+		key, value, found := strings.Cut(rest, "=")
+		if !found || key == "" || strings.ContainsAny(key, " \t\"") {
+			return nil, false
+		}
+
+		if strings.HasPrefix(value, `"`) {
+			end := 1
+			for end < len(value) && (value[end] != '"' || value[end-1] == '\\') {
+				end++
+			}
+			if end == len(value) {
+				return nil, false
+			}
+			unquoted, err := strconv.Unquote(value[:end+1])
+			if err != nil {
+				return nil, false
+			}
+			fields[key] = unquoted
+			rest = value[end+1:]
+			if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+				return nil, false
+			}
+		} else {
+			v, r, _ := strings.Cut(value, " ")
+			fields[key] = v
+			rest = r
+		}
+		rest = strings.TrimLeft(rest, " \t")
+	}
+
+	return fields, len(fields) >= 2
+}
+
+// parseLogLine detects JSON and logfmt lines and the level of the line.
+func parseLogLine(line string) (map[string]interface{}, string) {
+	text := strings.TrimSpace(line)
+
+	var fields map[string]interface{}
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal([]byte(text), &fields); err != nil {
+			fields = nil
+		}
+	}
+	if fields == nil && strings.Contains(text, "=") {
+		fields, _ = parseLogfmt(text)
+	}
+
+	for _, key := range logLevelKeys {
+		for k, v := range fields {
+			if s, ok := v.(string); ok && strings.EqualFold(k, key) {
+				if level := normalizeLogLevel(s); level != "" {
+					return fields, level
+				}
+			}
+		}
+	}
+
+	if m := klogLevelRegexp.FindStringSubmatch(text); m != nil {
+		return fields, klogLevels[m[1]]
+	}
+	if m := textLevelRegexp.FindString(text); m != "" {
+		return fields, normalizeLogLevel(m)
+	}
+
+	return fields, ""
+}
+
+// runeOffsets converts the byte offsets of regexp matches to rune offsets,
+// which the client can use to highlight the matches.
+func runeOffsets(text string, matches [][]int) [][]int {
+	offsets := make([][]int, 0, len(matches))
+	for _, m := range matches {
+		offsets = append(offsets, []int{
+			utf8.RuneCountInString(text[:m[0]]),
+			utf8.RuneCountInString(text[:m[1]]),
+		})
+	}
+	return offsets
+}
+
+// Apply parses the line and reports whether it passes the filter. The
+// matches of the include filter are set as highlights.
+func (f *logFilter) Apply(line *LogLine) bool {
+	line.Fields, line.Level = parseLogLine(line.Data)
+
+	if f == nil {
+		return true
+	}
+
+	text := strings.TrimRight(line.Data, "\r\n")
+	if f.exclude != nil && f.exclude.MatchString(text) {
+		return false
+	}
+	if f.include != nil {
+		matches := f.include.FindAllStringIndex(text, -1)
+		if matches == nil {
+			return false
+		}
+		line.Highlights = runeOffsets(text, matches)
+	}
+	if f.minLevel != "" && line.Level != "" && logLevelRanks[line.Level] < logLevelRanks[f.minLevel] {
+		return false
+	}
+
+	return true
+}
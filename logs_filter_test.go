@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want map[string]interface{}
+		ok   bool
+	}{
+		{
+			name: "plain pairs",
+			line: "level=info took=3ms",
+			want: map[string]interface{}{"level": "info", "took": "3ms"},
+			ok:   true,
+		},
+		{
+			name: "quoted value",
+			line: `level=info msg="request done" took=3ms`,
+			want: map[string]interface{}{"level": "info", "msg": "request done", "took": "3ms"},
+			ok:   true,
+		},
+		{
+			name: "escaped quotes",
+			line: `level=warn msg="say \"hi\" twice"`,
+			want: map[string]interface{}{"level": "warn", "msg": `say "hi" twice`},
+			ok:   true,
+		},
+		{
+			name: "empty value",
+			line: "level= msg=done",
+			want: map[string]interface{}{"level": "", "msg": "done"},
+			ok:   true,
+		},
+		{
+			name: "extra whitespace",
+			line: "  level=info \t msg=done  ",
+			want: map[string]interface{}{"level": "info", "msg": "done"},
+			ok:   true,
+		},
+		{
+			name: "single pair",
+			line: "level=info",
+			want: map[string]interface{}{"level": "info"},
+		},
+		{
+			name: "text with a single '='",
+			line: "the answer is x=42 today",
+		},
+		{
+			name: "text after pairs",
+			line: "level=info msg=done and more",
+		},
+		{
+			name: "unterminated quote",
+			line: `level=info msg="request done`,
+		},
+		{
+			name: "text after a quoted value",
+			line: `level=info msg="done"more`,
+		},
+		{
+			name: "empty key",
+			line: "=info msg=done",
+		},
+		{
+			name: "empty line",
+			line: "",
+			want: map[string]interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseLogfmt(tt.line)
+			if ok != tt.ok {
+				t.Fatalf("got ok %v, want %v", ok, tt.ok)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		fields map[string]interface{}
+		level  string
+	}{
+		{
+			name:   "json",
+			line:   `{"level":"error","msg":"failed"}`,
+			fields: map[string]interface{}{"level": "error", "msg": "failed"},
+			level:  "error",
+		},
+		{
+			name:   "json level alias",
+			line:   `{"severity":"WARNING","msg":"slow"}`,
+			fields: map[string]interface{}{"msg": "slow", "severity": "WARNING"},
+			level:  "warn",
+		},
+		{
+			name:   "json level key case",
+			line:   `{"Level":"dbg"}`,
+			fields: map[string]interface{}{"Level": "dbg"},
+			level:  "debug",
+		},
+		{
+			name:   "json without a known level",
+			line:   `{"level":"loud","msg":"ERROR in text"}`,
+			fields: map[string]interface{}{"level": "loud", "msg": "ERROR in text"},
+			level:  "error",
+		},
+		{
+			name:  "invalid json",
+			line:  `{"level":"error"`,
+			level: "",
+		},
+		{
+			name:   "logfmt",
+			line:   `lvl=eror msg="disk full"`,
+			fields: map[string]interface{}{"lvl": "eror", "msg": "disk full"},
+			level:  "error",
+		},
+		{
+			name:   "logfmt alias",
+			line:   "level=crit component=db",
+			fields: map[string]interface{}{"component": "db", "level": "crit"},
+			level:  "fatal",
+		},
+		{
+			name:   "logfmt notice",
+			line:   "level=notice component=db",
+			fields: map[string]interface{}{"component": "db", "level": "notice"},
+			level:  "info",
+		},
+		{
+			name:  "klog info",
+			line:  "I0612 10:15:02.123456       1 controller.go:42] synced",
+			level: "info",
+		},
+		{
+			name:  "klog warning",
+			line:  "W0612 10:15:02.123456       1 reflector.go:539] watch closed",
+			level: "warn",
+		},
+		{
+			name:  "klog error",
+			line:  "E0612 10:15:02.123456       1 server.go:10] failed",
+			level: "error",
+		},
+		{
+			name:  "klog fatal",
+			line:  "F0612 10:15:02.123456       1 main.go:1] exiting",
+			level: "fatal",
+		},
+		{
+			name:  "text level",
+			line:  "2024-06-12 10:15:02 WARNING disk almost full",
+			level: "warn",
+		},
+		{
+			name:  "text panic",
+			line:  "PANIC: runtime error",
+			level: "fatal",
+		},
+		{
+			name:  "lower case text is no level",
+			line:  "an error occurred",
+			level: "",
+		},
+		{
+			name:  "text with a single '='",
+			line:  "retrying with backoff=5s",
+			level: "",
+		},
+		{
+			name:  "text with a single '=' and a level",
+			line:  "ERROR retrying with backoff=5s",
+			level: "error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, level := parseLogLine(tt.line)
+			if fmt.Sprint(fields) != fmt.Sprint(tt.fields) {
+				t.Errorf("got fields %v, want %v", fields, tt.fields)
+			}
+			if level != tt.level {
+				t.Errorf("got level %q, want %q", level, tt.level)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+var LogWorkloadKind = struct {
+	deployment,
+	statefulSet,
+	daemonSet,
+	job string
+}{
+	deployment:  "deployment",
+	statefulSet: "statefulset",
+	daemonSet:   "daemonset",
+	job:         "job",
+}
+
+func (pld *PodLogsData) multiPod() bool {
+	return pld.Kind != "" || pld.LabelSelector != ""
+}
+
+// podSelector returns the selector of the pods of the workload, or the label
+// selector of the session when no workload is given.
+func (pld *PodLogsData) podSelector(ctx context.Context, client kubernetes.Interface) (labels.Selector, error) {
+	if pld.Kind == "" {
+		return labels.Parse(pld.LabelSelector)
+	}
+
+	var (
+		ls  *metav1.LabelSelector
+		err error
+	)
+	switch strings.ToLower(pld.Kind) {
+	case LogWorkloadKind.deployment:
+		var d *appsv1.Deployment
+		if d, err = client.AppsV1().Deployments(pld.Namespace).Get(ctx, pld.Name, metav1.GetOptions{}); err == nil {
+			ls = d.Spec.Selector
+		}
+	case LogWorkloadKind.statefulSet:
+		var sts *appsv1.StatefulSet
+		if sts, err = client.AppsV1().StatefulSets(pld.Namespace).Get(ctx, pld.Name, metav1.GetOptions{}); err == nil {
+			ls = sts.Spec.Selector
+		}
+	case LogWorkloadKind.daemonSet:
+		var ds *appsv1.DaemonSet
+		if ds, err = client.AppsV1().DaemonSets(pld.Namespace).Get(ctx, pld.Name, metav1.GetOptions{}); err == nil {
+			ls = ds.Spec.Selector
+		}
+	case LogWorkloadKind.job:
+		var job *batchv1.Job
+		if job, err = client.BatchV1().Jobs(pld.Namespace).Get(ctx, pld.Name, metav1.GetOptions{}); err == nil {
+			ls = job.Spec.Selector
+		}
+	default:
+		return nil, fmt.Errorf("unsupported workload kind '%s'", pld.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ls == nil {
+		return nil, fmt.Errorf("%s '%s' has no pod selector", pld.Kind, pld.Name)
+	}
+
+	return metav1.LabelSelectorAsSelector(ls)
+}
+
+// podLogs multiplexes the logs of all containers of the selected pods over
+// one log session. Every container gets a color index in the order it shows
+// up, a restarted container is streamed again with the same color.
+type podLogs struct {
+	stream  *logStream
+	client  kubernetes.Interface
+	pld     *PodLogsData
+	lock    sync.Mutex
+	started map[string]bool
+	colors  map[string]int
+	wg      sync.WaitGroup
+}
+
+func (pl *podLogs) startPod(ctx context.Context, pod *corev1.Pod) {
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	for _, cs := range statuses {
+		if cs.State.Running == nil && cs.State.Terminated == nil {
+			continue
+		}
+
+		container := pod.Name + "/" + cs.Name
+		key := fmt.Sprintf("%s/%d", container, cs.RestartCount)
+		if pl.started[key] {
+			continue
+		}
+		pl.started[key] = true
+
+		color, ok := pl.colors[container]
+		if !ok {
+			color = len(pl.colors)
+			pl.colors[container] = color
+		}
+
+		pl.wg.Add(1)
+		go pl.streamContainer(ctx, pod.Name, cs.Name, color)
+	}
+}
+
+func (pl *podLogs) streamContainer(ctx context.Context, pod, container string, color int) {
+	defer pl.wg.Done()
+
+	opts := pl.pld.Options.DeepCopy()
+	opts.Container = container
+	opts.Timestamps = true
+
+	out, err := pl.client.CoreV1().RESTClient().Get().
+		Namespace(pl.pld.Namespace).
+		Name(pod).
+		Resource("pods").
+		SubResource("log").
+		VersionedParams(opts, pl.pld.ParameterCodec).
+		Stream(ctx)
+	if err != nil {
+		pl.pushError(ctx, pod, container, color, err)
+		return
+	}
+	defer out.Close()
+
+	err = scanLogLines(out, opts.Timestamps, func(line LogLine) error {
+		line.Pod, line.Container, line.Color = pod, container, color
+		pl.stream.Push(line)
+		return nil
+	})
+	if err != nil {
+		pl.pushError(ctx, pod, container, color, err)
+	}
+}
+
+// pushError reports a container whose logs could not be streamed, like a
+// container that is still being created, instead of leaving it silent.
+func (pl *podLogs) pushError(ctx context.Context, pod, container string, color int, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	pl.stream.Push(LogLine{
+		Pod:       pod,
+		Container: container,
+		Color:     color,
+		Data:      fmt.Sprintf("error streaming logs of %s/%s: %s\n", pod, container, err),
+		Error:     err.Error(),
+	})
+}
+
+// watchPods starts the streams of pods created or restarted after the
+// initial list, until the log session is closed.
+func (pl *podLogs) watchPods(ctx context.Context, selector labels.Selector, resourceVersion string) {
+	rw, err := watchtools.NewRetryWatcher(resourceVersion, &cache.ListWatch{
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = selector.String()
+			return pl.client.CoreV1().Pods(pl.pld.Namespace).Watch(ctx, options)
+		},
+	})
+	if err != nil {
+		return
+	}
+	defer rw.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-rw.ResultChan():
+			if !ok {
+				return
+			}
+			if event.Type != watch.Added && event.Type != watch.Modified {
+				continue
+			}
+			if pod, ok := event.Object.(*corev1.Pod); ok {
+				pl.startPod(ctx, pod)
+			}
+		}
+	}
+}
+
+// SendPodsLogs streams the logs of every container of the pods of a workload
+// or label selector. When following, new pods are picked up by a watch and
+// the session stays open until the client closes it.
+func (lss LogStreamSession) SendPodsLogs(client kubernetes.Interface, pld *PodLogsData) error {
+	ctx := lss.stream.ctx
+
+	selector, err := pld.podSelector(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	pods, err := client.CoreV1().Pods(pld.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return err
+	}
+
+	pl := &podLogs{
+		stream:  lss.stream,
+		client:  client,
+		pld:     pld,
+		started: make(map[string]bool),
+		colors:  make(map[string]int),
+	}
+	for i := range pods.Items {
+		pl.startPod(ctx, &pods.Items[i])
+	}
+
+	if pld.Options.Follow {
+		go pl.watchPods(ctx, selector, pods.ResourceVersion)
+		return nil
+	}
+
+	go func() {
+		pl.wg.Wait()
+		lss.stream.Finish(WSCloseCode.info, "Log stream ended")
+	}()
+
+	return nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logBatchLines    = 500
+	logBatchInterval = 100 * time.Millisecond
+	logBufferLines   = 10000
+)
+
+// LogLine is a line of a log stream. Live streams always request timestamps
+// to skip the lines a reconnecting client already saw, Timestamp is only set
+// when the client asked for timestamps or resumes a stream.
+type LogLine struct {
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	// Pod, Container and Color tag the lines of multi-pod log sessions.
+	Pod       string `json:"pod,omitempty"`
+	Container string `json:"container,omitempty"`
+	Color     int    `json:"color,omitempty"`
+	// Level and Fields are detected from the line, Highlights are the rune
+	// offsets of the matches of the include filter.
+	Level      string                 `json:"level,omitempty"`
+	Fields     map[string]interface{} `json:"fields,omitempty"`
+	Highlights [][]int                `json:"highlights,omitempty"`
+	Data       string                 `json:"data"`
+	// Error is set on the line reporting that the logs of a container of a
+	// multi-pod session could not be streamed.
+	Error string `json:"error,omitempty"`
+}
+
+// scanLogLines calls fn for every line of the logs until the end of the
+// stream or the first error of fn.
+func scanLogLines(r io.Reader, timestamps bool, fn func(line LogLine) error) error {
+	reader := bufio.NewReader(r)
+	for {
+		// The last line has no newline when limitBytes cuts the logs.
+		b, err := reader.ReadBytes('\n')
+		if len(b) > 0 {
+			var line LogLine
+			line.Timestamp, line.Data = splitLogTimestamp(string(b), timestamps)
+			if err := fn(line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// logStream buffers the lines of the log requests of a session and writes
+// them in batches of logBatchLines or every logBatchInterval. The buffer is
+// bounded, when the client can't keep up the oldest lines are dropped and the
+// next batch reports how many. Every session has one stream, it is cancelled
+// with the session.
+type logStream struct {
+	lss        LogStreamSession
+	ctx        context.Context
+	cancel     context.CancelFunc
+	resumeFrom *time.Time
+	timestamps bool
+	notify     chan struct{}
+	started    bool
+	lines      []LogLine
+	dropped    int
+	lock       sync.Mutex
+	flushLock  sync.Mutex
+}
+
+func newLogStream(resumeFrom *time.Time, timestamps bool) *logStream {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &logStream{
+		ctx:        ctx,
+		cancel:     cancel,
+		resumeFrom: resumeFrom,
+		timestamps: timestamps || resumeFrom != nil,
+		notify:     make(chan struct{}, 1),
+	}
+}
+
+// Start reports whether the stream was started by this call, a stream is
+// only started once per session.
+func (s *logStream) Start(lss LogStreamSession) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.started || s.ctx.Err() != nil {
+		return false
+	}
+	s.started = true
+	s.lss = lss
+
+	go s.run()
+
+	return true
+}
+
+func (s *logStream) Stop() {
+	s.cancel()
+}
+
+// Push buffers the line when it passes the filter of the session. Lines the
+// client saw before it reconnected are skipped.
+func (s *logStream) Push(line LogLine) {
+	// Error lines are never filtered, the client has to see them.
+	if line.Error == "" {
+		if s.resumeFrom != nil && line.Timestamp != nil && !line.Timestamp.After(*s.resumeFrom) {
+			return
+		}
+		if !s.timestamps {
+			line.Timestamp = nil
+		}
+		if !s.lss.filter.Get().Apply(&line) {
+			return
+		}
+	}
+
+	s.lock.Lock()
+	if len(s.lines) >= logBufferLines {
+		s.lines = s.lines[1:]
+		s.dropped++
+	}
+	s.lines = append(s.lines, line)
+	full := len(s.lines) >= logBatchLines
+	s.lock.Unlock()
+
+	if full {
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *logStream) take() ([]LogLine, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	n := len(s.lines)
+	if n > logBatchLines {
+		n = logBatchLines
+	}
+	lines := append([]LogLine{}, s.lines[:n]...)
+	s.lines = s.lines[n:]
+	dropped := s.dropped
+	s.dropped = 0
+
+	return lines, dropped
+}
+
+// flush writes the buffered lines. Data holds the text of the batch, so
+// clients only showing text don't need to look at the lines.
+func (s *logStream) flush() error {
+	s.flushLock.Lock()
+	defer s.flushLock.Unlock()
+
+	for s.ctx.Err() == nil {
+		lines, dropped := s.take()
+		if len(lines) == 0 && dropped == 0 {
+			return nil
+		}
+
+		var data strings.Builder
+		if dropped > 0 {
+			fmt.Fprintf(&data, "... %d lines dropped ...\n", dropped)
+		}
+		for _, line := range lines {
+			data.WriteString(line.Data)
+		}
+
+		lsm := LogStreamMessage{
+			Op:        WSOpType.stdout,
+			SessionID: s.lss.id,
+			Data:      data.String(),
+			Lines:     lines,
+			Dropped:   dropped,
+		}
+		if err := s.lss.WriteJSON(lsm); err != nil {
+			return err
+		}
+	}
+
+	return s.ctx.Err()
+}
+
+func (s *logStream) run() {
+	ticker := time.NewTicker(logBatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.notify:
+		}
+
+		if err := s.flush(); err != nil {
+			s.cancel()
+			return
+		}
+	}
+}
+
+// Finish writes the remaining lines and closes the websocket, the session is
+// closed when its read loop fails.
+func (s *logStream) Finish(status uint, reason string) {
+	if s.ctx.Err() != nil {
+		return
+	}
+	if err := s.flush(); err != nil {
+		return
+	}
+
+	lsm := LogStreamMessage{
+		Op:         "close",
+		Data:       reason,
+		StatusCode: status,
+	}
+	if status == WSCloseCode.error {
+		lsm.Error = reason
+	}
+	s.lss.WriteJSON(lsm)
+	s.lss.ws.Close()
+}
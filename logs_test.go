@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// formatPodLogOptions prints the options parsePodLogOptions sets, nil
+// pointers as <nil>.
+func formatPodLogOptions(opts *corev1.PodLogOptions) string {
+	ptr := func(n *int64) string {
+		if n == nil {
+			return "<nil>"
+		}
+		return fmt.Sprint(*n)
+	}
+	sinceTime := "<nil>"
+	if opts.SinceTime != nil {
+		sinceTime = opts.SinceTime.UTC().Format(time.RFC3339)
+	}
+
+	return fmt.Sprintf("container=%s follow=%t previous=%t timestamps=%t tailLines=%s sinceSeconds=%s limitBytes=%s sinceTime=%s",
+		opts.Container, opts.Follow, opts.Previous, opts.Timestamps,
+		ptr(opts.TailLines), ptr(opts.SinceSeconds), ptr(opts.LimitBytes), sinceTime)
+}
+
+func TestParsePodLogOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+		err   string
+	}{
+		{
+			name:  "empty",
+			query: "",
+			want:  "container= follow=false previous=false timestamps=false tailLines=<nil> sinceSeconds=<nil> limitBytes=<nil> sinceTime=<nil>",
+		},
+		{
+			name:  "flags",
+			query: "container=app&follow=true&previous=1&timestamps=true",
+			want:  "container=app follow=true previous=true timestamps=true tailLines=<nil> sinceSeconds=<nil> limitBytes=<nil> sinceTime=<nil>",
+		},
+		{
+			name:  "invalid flag",
+			query: "follow=yes",
+			err:   "invalid 'follow' value 'yes'",
+		},
+		{
+			name:  "tailLines zero",
+			query: "tailLines=0",
+			want:  "container= follow=false previous=false timestamps=false tailLines=0 sinceSeconds=<nil> limitBytes=<nil> sinceTime=<nil>",
+		},
+		{
+			name:  "limitBytes zero",
+			query: "limitBytes=0",
+			err:   "invalid 'limitBytes' value '0'",
+		},
+		{
+			name:  "sinceSeconds zero",
+			query: "sinceSeconds=0",
+			err:   "invalid 'sinceSeconds' value '0'",
+		},
+		{
+			name:  "negative tailLines",
+			query: "tailLines=-1",
+			err:   "invalid 'tailLines' value '-1'",
+		},
+		{
+			name:  "numbers",
+			query: "tailLines=100&limitBytes=2048&sinceSeconds=60",
+			want:  "container= follow=false previous=false timestamps=false tailLines=100 sinceSeconds=60 limitBytes=2048 sinceTime=<nil>",
+		},
+		{
+			name:  "sinceTime",
+			query: "sinceTime=2024-06-12T10:15:02Z",
+			want:  "container= follow=false previous=false timestamps=false tailLines=<nil> sinceSeconds=<nil> limitBytes=<nil> sinceTime=2024-06-12T10:15:02Z",
+		},
+		{
+			name:  "invalid sinceTime",
+			query: "sinceTime=yesterday",
+			err:   "invalid 'sinceTime' value 'yesterday', expected RFC3339",
+		},
+		{
+			name:  "sinceSeconds and sinceTime",
+			query: "sinceSeconds=60&sinceTime=2024-06-12T10:15:02Z",
+			err:   "only one of 'sinceSeconds' and 'sinceTime' can be set",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			opts, err := parsePodLogOptions(query)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := formatPodLogOptions(opts)
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
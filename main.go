@@ -3,11 +3,13 @@ package main
 import (
 	"embed"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -15,8 +17,10 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 
 	"github.com/urfave/cli/v2"
+	"helm.sh/helm/v3/pkg/chart/loader"
 	authv1 "k8s.io/api/authorization/v1"
-	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -38,6 +42,7 @@ type FrontendRequest struct {
 	KubeGVRK        KubeGVRK        `json:"kubeGVRK"`
 	KubeGVRKList    []KubeGVRK      `json:"kubeGVRKList"`
 	Data            string          `json:"data"`
+	Original        string          `json:"original"`
 	ResourceOptions ResourceOptions `json:"kubeOptions"`
 	HelmOptions     HelmOptions     `json:"helmOptions"`
 }
@@ -51,30 +56,49 @@ type KubeGVRK struct {
 }
 
 type HelmOptions struct {
-	ChartName    string `json:"chartName"`
-	ChartVersion string `json:"chartVersion"`
-	EnvPath      string `json:"envPath"`
-	RepoURL      string `json:"repoURL"`
-	DryRun       bool   `json:"dryRun"`
-	IsOCI        bool   `json:"isOCI"`
-	ReuseValues  bool   `json:"reuseValues"`
+	ChartName        string              `json:"chartName"`
+	ChartVersion     string              `json:"chartVersion"`
+	EnvPath          string              `json:"envPath"`
+	RepoURL          string              `json:"repoURL"`
+	DryRun           bool                `json:"dryRun"`
+	IsOCI            bool                `json:"isOCI"`
+	ReuseValues      bool                `json:"reuseValues"`
+	Revision         int                 `json:"revision"`
+	Wait             bool                `json:"wait"`
+	Atomic           bool                `json:"atomic"`
+	TimeoutSeconds   int64               `json:"timeoutSeconds"`
+	LocalChart       string              `json:"localChart"`
+	DependencyUpdate bool                `json:"dependencyUpdate"`
+	Lint             HelmLintOptions     `json:"lint"`
+	Repository       HelmRepoOptions     `json:"repository"`
+	Registry         HelmRegistryOptions `json:"registry"`
+	Search           HelmSearchOptions   `json:"search"`
 }
 
 type ResourceOptions struct {
-	FieldSelector  string `json:"fieldSelector"`
-	LabelSelector  string `json:"labelSelector"`
-	TimeoutSeconds int64  `json:"timeoutSeconds"`
-	Limit          int64  `json:"limit"`
-	Continue       string `json:"continue"`
+	FieldSelector   string `json:"fieldSelector"`
+	LabelSelector   string `json:"labelSelector"`
+	TimeoutSeconds  int64  `json:"timeoutSeconds"`
+	Limit           int64  `json:"limit"`
+	Continue        string `json:"continue"`
+	ResourceVersion string `json:"resourceVersion"`
+	FieldManager    string `json:"fieldManager"`
+	Force           bool   `json:"force"`
+	DryRun          bool   `json:"dryRun"`
+	PatchType       string `json:"patchType"`
+	Subresource     string `json:"subresource"`
+	DiffStrategy    string `json:"diffStrategy"`
 }
 
 type APIResource struct {
 	CliContext    *cli.Context
+	ClusterID     string
 	AuthRequest   *AuthRequest
 	AuthState     bool
 	SSAR          *authv1.SelfSubjectAccessReview
 	Clientset     *kubernetes.Clientset
 	DynamicClient *dynamic.DynamicClient
+	RESTMapper    meta.ResettableRESTMapper
 	Error         error
 	Config        *rest.Config
 	Helm          *Helm
@@ -87,12 +111,15 @@ type APIResourceMessage struct {
 }
 
 var (
-	apiRes   = APIResource{}
+	authSessions = AuthSessionStore{
+		Defaults: &ClusterRegistry{Clusters: make(map[string]*APIResource)},
+		Sessions: make(map[string]*AuthSession),
+	}
 	upgrader = websocket.Upgrader{}
 )
 
-func (ar *APIResource) DataStreamWSHandler(c echo.Context) error {
-	if !ar.AuthState && ar.Config == nil {
+func (cr *ClusterRegistry) DataStreamWSHandler(c echo.Context) error {
+	if !cr.Authenticated() {
 		return c.JSON(http.StatusUnauthorized, APIResourceMessage{StatusCode: http.StatusUnauthorized})
 	}
 
@@ -106,8 +133,9 @@ func (ar *APIResource) DataStreamWSHandler(c echo.Context) error {
 	return nil
 }
 
-func (ar *APIResource) DataStreamWS(c echo.Context) error {
-	if !ar.AuthState && ar.Config == nil {
+func (cr *ClusterRegistry) DataStreamWS(c echo.Context) error {
+	ar := cr.Resolve(c.QueryParam("cluster"))
+	if ar == nil {
 		return c.JSON(http.StatusUnauthorized,
 			APIResourceMessage{StatusCode: http.StatusUnauthorized, Error: "Not authenticated"})
 	}
@@ -121,17 +149,20 @@ func (ar *APIResource) DataStreamWS(c echo.Context) error {
 	}
 
 	dataSessions.Set(sessionID, DataStreamSession{
-		id:    sessionID,
-		bound: make(chan error),
-		close: make(chan struct{}),
+		id:      sessionID,
+		bound:   make(chan error),
+		close:   make(chan struct{}),
+		lock:    &sync.Mutex{},
+		watches: &DataWatchMap{Watches: make(map[string]*DataWatch)},
+		owner:   newHelmJobOwner(cr.SessionID, cr.Identity),
 	})
 	go WaitForDataStream(ar, sessionID)
 
 	return c.JSON(http.StatusOK, APIResourceMessage{SessionID: sessionID, StatusCode: http.StatusOK})
 }
 
-func (ar *APIResource) ShellWSHandler(c echo.Context) error {
-	if !ar.AuthState && ar.Config == nil {
+func (cr *ClusterRegistry) ShellWSHandler(c echo.Context) error {
+	if !cr.Authenticated() {
 		return c.JSON(http.StatusUnauthorized, APIResourceMessage{StatusCode: http.StatusUnauthorized})
 	}
 
@@ -145,8 +176,9 @@ func (ar *APIResource) ShellWSHandler(c echo.Context) error {
 	return nil
 }
 
-func (ar *APIResource) ExecShell(c echo.Context) error {
-	if !ar.AuthState && ar.Config == nil {
+func (cr *ClusterRegistry) ExecShell(c echo.Context) error {
+	ar := cr.Resolve(c.QueryParam("cluster"))
+	if ar == nil {
 		return c.JSON(http.StatusUnauthorized,
 			APIResourceMessage{StatusCode: http.StatusUnauthorized, Error: "Not authenticated"})
 	}
@@ -188,8 +220,8 @@ func (ar *APIResource) ExecShell(c echo.Context) error {
 	return c.JSON(http.StatusOK, APIResourceMessage{SessionID: sessionID, StatusCode: http.StatusOK})
 }
 
-func (ar *APIResource) LogsWSHandler(c echo.Context) error {
-	if !ar.AuthState && ar.Config == nil {
+func (cr *ClusterRegistry) LogsWSHandler(c echo.Context) error {
+	if !cr.Authenticated() {
 		return c.JSON(http.StatusUnauthorized, APIResourceMessage{StatusCode: http.StatusUnauthorized})
 	}
 
@@ -203,22 +235,67 @@ func (ar *APIResource) LogsWSHandler(c echo.Context) error {
 	return nil
 }
 
-func (ar *APIResource) StreamLogs(c echo.Context) error {
-	if !ar.AuthState && ar.Config == nil {
-		return c.JSON(http.StatusUnauthorized, APIResourceMessage{StatusCode: http.StatusUnauthorized})
+// podLogsDataFromQuery reads the pod or workload, the log options and the
+// filter of a log stream or download.
+func podLogsDataFromQuery(c echo.Context) (*PodLogsData, error) {
+	logOpts, err := parsePodLogOptions(c.QueryParams())
+	if err != nil {
+		return nil, err
+	}
+
+	logFilter, err := compileLogFilter(LogFilter{
+		Include:  c.QueryParam("include"),
+		Exclude:  c.QueryParam("exclude"),
+		MinLevel: c.QueryParam("level"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var resumeFrom *time.Time
+	if v := c.QueryParam("resumeFrom"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'resumeFrom' value '%s', expected RFC3339", v)
+		}
+		resumeFrom = &t
+
+		// The log API takes whole seconds, the lines of that second the
+		// client already saw are skipped by the stream.
+		logOpts.SinceTime = &metav1.Time{Time: t.Truncate(time.Second)}
+		logOpts.SinceSeconds = nil
+		logOpts.TailLines = nil
 	}
 
 	var pld PodLogsData
 	pld.ResourceType = "pods"
 	pld.Name = c.QueryParam("name")
 	pld.Namespace = c.QueryParam("namespace")
-	pld.ContainerName = c.QueryParam("container")
-	pld.Follow = c.QueryParam("follow")
-	pld.TailLines = c.QueryParam("tailLines")
-	pld.Options = &corev1.PodLogOptions{}
+	pld.Kind = c.QueryParam("kind")
+	pld.LabelSelector = c.QueryParam("labelSelector")
+	pld.Options = logOpts
 	pld.ParameterCodec = scheme.ParameterCodec
+	pld.Filter = logFilter
+	pld.ResumeFrom = resumeFrom
 
-	sessionID, err := genSessionId()
+	return &pld, nil
+}
+
+func (cr *ClusterRegistry) StreamLogs(c echo.Context) error {
+	ar := cr.Resolve(c.QueryParam("cluster"))
+	if ar == nil {
+		return c.JSON(http.StatusUnauthorized, APIResourceMessage{StatusCode: http.StatusUnauthorized})
+	}
+
+	pld, err := podLogsDataFromQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, LogStreamMessage{
+			Error:      err.Error(),
+			StatusCode: http.StatusBadRequest,
+		})
+	}
+
+	sessionID, err := newLogSession(ar.Clientset, pld)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, LogStreamMessage{
 			Error:      err.Error(),
@@ -226,19 +303,67 @@ func (ar *APIResource) StreamLogs(c echo.Context) error {
 		})
 	}
 
-	logStreamSessions.Set(sessionID, LogStreamSession{
-		id:    sessionID,
-		bound: make(chan error),
-		close: make(chan struct{}),
-	})
-	go pld.WaitForLogs(ar.Clientset, sessionID)
-
 	return c.JSON(http.StatusOK, LogStreamMessage{SessionID: sessionID, StatusCode: http.StatusOK})
 }
 
+// DownloadLogs exports the logs of a pod, container or workload as a text,
+// NDJSON or gzipped tar file with one file per container.
+func (cr *ClusterRegistry) DownloadLogs(c echo.Context) error {
+	ar := cr.Resolve(c.QueryParam("cluster"))
+	if ar == nil {
+		return c.JSON(http.StatusUnauthorized, APIResourceMessage{StatusCode: http.StatusUnauthorized})
+	}
+
+	pld, err := podLogsDataFromQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, LogStreamMessage{
+			Error:      err.Error(),
+			StatusCode: http.StatusBadRequest,
+		})
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = LogExportFormat.text
+	}
+	ext, ok := logExportExtensions[format]
+	if !ok {
+		return c.JSON(http.StatusBadRequest, LogStreamMessage{
+			Error:      fmt.Sprintf("unsupported log export format '%s'", format),
+			StatusCode: http.StatusBadRequest,
+		})
+	}
+
+	ctx := c.Request().Context()
+	sources, err := pld.logSources(ctx, ar.Clientset)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, LogStreamMessage{
+			Error:      err.Error(),
+			StatusCode: http.StatusInternalServerError,
+		})
+	}
+
+	name := pld.Name
+	if name == "" {
+		name = "logs"
+	}
+	filename := fmt.Sprintf("%s-%s-%s%s", pld.Namespace, name, time.Now().UTC().Format("20060102T150405Z"), ext)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, logExportContentTypes[format])
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	res.WriteHeader(http.StatusOK)
+
+	// The status is sent already, errors of single containers end up in the
+	// exported file.
+	return exportLogs(ctx, res, ar.Clientset, pld, sources, format)
+}
+
 type AuthResponse struct {
 	Error        string                          `json:"error"`
+	ClusterID    string                          `json:"clusterId"`
 	KubeHost     string                          `json:"kubeHost"`
+	Context      string                          `json:"context"`
 	State        bool                            `json:"state"`
 	AccessReview *authv1.SelfSubjectAccessReview `json:"accessReview"`
 }
@@ -250,51 +375,251 @@ type AuthRequest struct {
 	KubeconfigRaw  string `json:"kubeconfigRaw"`
 	MasterURL      string `json:"masterURL"`
 	TLSInsecure    bool   `json:"tlsInsecure"`
+	Context        string `json:"context"`
+	ClusterID      string `json:"clusterId"`
 }
 
-func (ar *APIResource) Auth(c echo.Context) (err error) {
-	if err := c.Bind(ar.AuthRequest); err != nil {
+func (cr *ClusterRegistry) Auth(c echo.Context) (err error) {
+	var authReq AuthRequest
+	if err := c.Bind(&authReq); err != nil {
 		return c.JSON(http.StatusUnauthorized, AuthResponse{
 			Error: fmt.Sprintf("Auth error: could not initialize cluster config: %s", err),
 			State: false,
 		})
 	}
 
-	if ar.AuthRequest.Type == "authUnset" {
-		if errUnset := authUnset(ar); errUnset != nil {
-			return c.JSON(http.StatusUnauthorized, AuthResponse{
-				Error: fmt.Sprintf("Auth error: %s", errUnset),
-				State: false,
-			})
+	clusterID := clusterIDOrDefault(authReq.ClusterID)
+	authReq.ClusterID = clusterID
+
+	if authReq.Type == "authUnset" {
+		if ar := cr.Get(clusterID); ar != nil {
+			if errUnset := authUnset(ar); errUnset != nil {
+				return c.JSON(http.StatusUnauthorized, AuthResponse{
+					Error:     fmt.Sprintf("Auth error: %s", errUnset),
+					ClusterID: clusterID,
+					State:     false,
+				})
+			}
 		}
+		cr.Delete(clusterID)
 
 		return c.JSON(http.StatusOK, AuthResponse{
-			State:    ar.AuthState,
-			KubeHost: "",
+			ClusterID: clusterID,
+			State:     false,
+			KubeHost:  "",
 		},
 		)
 	}
 
-	ssar, errInit := authInit(ar)
+	if authReq.Type == "authSwitchContext" {
+		ar := cr.Get(clusterID)
+		if ar == nil || !ar.AuthState || ar.AuthRequest == nil || (ar.AuthRequest.Type != KubernetesConfigType.kubeconfigPath &&
+			ar.AuthRequest.Type != KubernetesConfigType.kubeconfigRaw) {
+			return c.JSON(http.StatusBadRequest, AuthResponse{
+				Error:     "Auth error: switching context requires a kubeconfig based authentication",
+				ClusterID: clusterID,
+				State:     ar != nil && ar.AuthState,
+			})
+		}
+
+		switchReq := *ar.AuthRequest
+		switchReq.Context = authReq.Context
+		authReq = switchReq
+	}
+
+	next := &APIResource{CliContext: cr.CliContext, AuthRequest: &authReq}
+	ssar, errInit := authInit(next)
+	if errInit == nil && cr.Identity != nil {
+		// The session belongs to a proxy user, the new credentials must not
+		// be used without impersonating that user.
+		next, errInit = impersonateAPIResource(next, cr.Identity)
+		if errInit == nil {
+			ssar = next.SSAR
+		}
+	}
 	if errInit != nil {
 		return c.JSON(http.StatusUnauthorized, AuthResponse{
 			Error:        fmt.Sprintf("Auth error: %s", errInit),
+			ClusterID:    clusterID,
 			State:        false,
 			AccessReview: ssar,
 		})
 	}
 
-	ar.AuthState = true
-	ar.SSAR = ssar
+	next.AuthState = true
+	next.SSAR = ssar
+	cr.scopeRegistryConfig(next)
+	cr.Set(clusterID, next)
 
 	return c.JSON(http.StatusOK, AuthResponse{
-		State:        ar.AuthState,
-		KubeHost:     ar.Config.Host,
-		AccessReview: ar.SSAR,
+		ClusterID:    clusterID,
+		State:        next.AuthState,
+		KubeHost:     next.Config.Host,
+		Context:      next.AuthRequest.Context,
+		AccessReview: next.SSAR,
 	},
 	)
 }
 
+func (cr *ClusterRegistry) AuthState(c echo.Context) error {
+	clusterID := clusterIDOrDefault(c.QueryParam("cluster"))
+
+	ar := cr.Get(clusterID)
+	if ar == nil {
+		return c.JSON(http.StatusOK, AuthResponse{
+			ClusterID: clusterID, State: false, AccessReview: &authv1.SelfSubjectAccessReview{},
+		})
+	}
+
+	var kubeHost, kubeContext string
+	if ar.Config != nil {
+		kubeHost = ar.Config.Host
+	}
+	if ar.AuthRequest != nil {
+		kubeContext = ar.AuthRequest.Context
+	}
+	return c.JSON(http.StatusOK, AuthResponse{
+		ClusterID: clusterID, State: ar.AuthState, KubeHost: kubeHost, Context: kubeContext, AccessReview: ar.SSAR,
+	},
+	)
+}
+
+func (cr *ClusterRegistry) ListClusters(c echo.Context) error {
+	return c.JSON(http.StatusOK, cr.List())
+}
+
+// KubeconfigContexts lists the contexts of an uploaded kubeconfig, which is
+// needed before the first login, or of the kubeconfig given with the
+// --kubeconfig flag. Reading the file on the server requires an authenticated
+// session, and this endpoint reads no other file.
+func (cr *ClusterRegistry) KubeconfigContexts(c echo.Context) error {
+	var authReq AuthRequest
+	if err := c.Bind(&authReq); err != nil {
+		return c.JSON(http.StatusBadRequest, APIResourceMessage{
+			Error:      err.Error(),
+			StatusCode: http.StatusBadRequest,
+		})
+	}
+
+	if authReq.Type == "" {
+		authReq.Type = KubernetesConfigType.kubeconfigPath
+	}
+	switch authReq.Type {
+	case KubernetesConfigType.kubeconfigPath:
+		if !cr.Authenticated() {
+			return c.JSON(http.StatusUnauthorized, APIResourceMessage{StatusCode: http.StatusUnauthorized})
+		}
+		if authReq.KubeconfigPath != "" && authReq.KubeconfigPath != kubeconfigFlagValue {
+			return c.JSON(http.StatusForbidden, APIResourceMessage{
+				Error:      "only the configured kubeconfig can be read",
+				StatusCode: http.StatusForbidden,
+			})
+		}
+		authReq.KubeconfigPath = kubeconfigFlagValue
+	case KubernetesConfigType.kubeconfigRaw:
+	default:
+		return c.JSON(http.StatusBadRequest, APIResourceMessage{
+			Error:      fmt.Sprintf("auth type '%s' does not use a kubeconfig", authReq.Type),
+			StatusCode: http.StatusBadRequest,
+		})
+	}
+
+	kc, err := listKubeconfigContexts(&authReq)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIResourceMessage{
+			Error:      fmt.Sprintf("could not read kubeconfig: %s", err),
+			StatusCode: http.StatusBadRequest,
+		})
+	}
+
+	return c.JSON(http.StatusOK, kc)
+}
+
+func (cr *ClusterRegistry) DownloadChart(c echo.Context) error {
+	if !cr.Authenticated() {
+		return c.JSON(http.StatusUnauthorized, APIResourceMessage{StatusCode: http.StatusUnauthorized})
+	}
+
+	chartPath, err := localChartPath(c.Param("ref"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, APIResourceMessage{
+			Error:      err.Error(),
+			StatusCode: http.StatusNotFound,
+		})
+	}
+
+	return c.Attachment(chartPath, filepath.Base(chartPath))
+}
+
+// UploadChart stores a chart in the chart cache, either a packaged archive
+// sent as 'chart' or the files of a chart directory sent as 'files' with
+// their relative paths in 'paths'.
+func (cr *ClusterRegistry) UploadChart(c echo.Context) error {
+	if !cr.Authenticated() {
+		return c.JSON(http.StatusUnauthorized, APIResourceMessage{StatusCode: http.StatusUnauthorized})
+	}
+
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxChartUploadSize)
+	form, err := c.MultipartForm()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIResourceMessage{
+			Error:      err.Error(),
+			StatusCode: http.StatusBadRequest,
+		})
+	}
+
+	var ci ChartInfo
+	if archives := form.File["chart"]; len(archives) > 0 {
+		f, errOpen := archives[0].Open()
+		if errOpen != nil {
+			return c.JSON(http.StatusBadRequest, APIResourceMessage{
+				Error:      errOpen.Error(),
+				StatusCode: http.StatusBadRequest,
+			})
+		}
+		defer f.Close()
+
+		ci, err = storeChartArchive(f)
+	} else {
+		var files []*loader.BufferedFile
+		paths := form.Value["paths"]
+		for idx, fh := range form.File["files"] {
+			name := fh.Filename
+			if idx < len(paths) {
+				name = paths[idx]
+			}
+
+			f, errOpen := fh.Open()
+			if errOpen != nil {
+				return c.JSON(http.StatusBadRequest, APIResourceMessage{
+					Error:      errOpen.Error(),
+					StatusCode: http.StatusBadRequest,
+				})
+			}
+			data, errRead := io.ReadAll(f)
+			f.Close()
+			if errRead != nil {
+				return c.JSON(http.StatusBadRequest, APIResourceMessage{
+					Error:      errRead.Error(),
+					StatusCode: http.StatusBadRequest,
+				})
+			}
+
+			files = append(files, &loader.BufferedFile{Name: name, Data: data})
+		}
+
+		ci, err = storeChartFiles(files)
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIResourceMessage{
+			Error:      err.Error(),
+			StatusCode: http.StatusBadRequest,
+		})
+	}
+
+	return c.JSON(http.StatusOK, ci)
+}
+
 var portFlagValue string
 var portFlag = &cli.StringFlag{
 	Name:        "port",
@@ -311,6 +636,13 @@ var kubeconfigFlag = &cli.StringFlag{
 	Destination: &kubeconfigFlagValue,
 }
 
+var kubeContextFlagValue string
+var kubeContextFlag = &cli.StringFlag{
+	Name:        "context",
+	Usage:       "kubeconfig context to use instead of the current-context",
+	Destination: &kubeContextFlagValue,
+}
+
 var kubeAccessTokenFlagValue string
 var kubeAccessTokenFlag = &cli.StringFlag{
 	Name:        "access-token",
@@ -332,6 +664,53 @@ var kubeInClusterConfigFlag = &cli.BoolFlag{
 	Destination: &kubeInClusterConfigFlagValue,
 }
 
+var sessionTTLFlagValue time.Duration
+var sessionTTLFlag = &cli.DurationFlag{
+	Name:        "session-ttl",
+	Usage:       "how long an idle browser session keeps its cluster connections",
+	Value:       12 * time.Hour,
+	Destination: &sessionTTLFlagValue,
+}
+
+var impersonateFlagValue bool
+var impersonateFlag = &cli.BoolFlag{
+	Name:        "impersonate",
+	Usage:       "impersonate the user and groups provided by a trusted authenticating proxy",
+	Destination: &impersonateFlagValue,
+}
+
+var impersonateUserHeaderFlagValue string
+var impersonateUserHeaderFlag = &cli.StringFlag{
+	Name:        "impersonate-user-header",
+	Usage:       "request header holding the user name set by the authenticating proxy",
+	Value:       defaultUserHeader,
+	Destination: &impersonateUserHeaderFlagValue,
+}
+
+var impersonateGroupsHeaderFlagValue string
+var impersonateGroupsHeaderFlag = &cli.StringFlag{
+	Name:        "impersonate-groups-header",
+	Usage:       "request header holding the comma separated groups set by the authenticating proxy",
+	Value:       defaultGroupsHeader,
+	Destination: &impersonateGroupsHeaderFlagValue,
+}
+
+var trustedProxiesFlagValue = cli.NewStringSlice(defaultTrustedProxyCIDRs...)
+var trustedProxiesFlag = &cli.StringSliceFlag{
+	Name:        "trusted-proxies",
+	Usage:       "IP addresses or CIDRs of the proxies allowed to set the impersonation headers",
+	Value:       trustedProxiesFlagValue,
+	Destination: trustedProxiesFlagValue,
+}
+
+var artifactHubURLFlagValue string
+var artifactHubURLFlag = &cli.StringFlag{
+	Name:        "artifact-hub-url",
+	Usage:       "Artifact Hub compatible server used for chart search, empty disables it",
+	Value:       defaultArtifactHubURL,
+	Destination: &artifactHubURLFlagValue,
+}
+
 var AppVersion = "0.0.0"
 
 func main() {
@@ -380,44 +759,60 @@ func main() {
 				Flags: []cli.Flag{
 					portFlag,
 					kubeconfigFlag,
+					kubeContextFlag,
 					kubeAccessTokenFlag,
 					kubeMasterURLFlag,
 					kubeInClusterConfigFlag,
+					sessionTTLFlag,
+					impersonateFlag,
+					impersonateUserHeaderFlag,
+					impersonateGroupsHeaderFlag,
+					trustedProxiesFlag,
+					artifactHubURLFlag,
 				},
 				Action: func(ctx *cli.Context) error {
-					apiRes.CliContext = ctx
-					apiRes.AuthRequest = &AuthRequest{}
-					apiRes.SSAR = &authv1.SelfSubjectAccessReview{}
+					authSessions.Defaults.CliContext = ctx
+					authSessions.TTL = sessionTTLFlagValue
+
+					trustedProxies, err := parseTrustedProxies(trustedProxiesFlagValue.Value())
+					if err != nil {
+						return err
+					}
+					authSessions.Impersonation = &Impersonation{
+						Enabled:        impersonateFlagValue,
+						UserHeader:     impersonateUserHeaderFlagValue,
+						GroupsHeader:   impersonateGroupsHeaderFlagValue,
+						TrustedProxies: trustedProxies,
+					}
+
+					ar := &APIResource{
+						CliContext:  ctx,
+						AuthRequest: &AuthRequest{ClusterID: defaultClusterID},
+						SSAR:        &authv1.SelfSubjectAccessReview{},
+					}
 
 					switch {
-					case apiRes.CliContext.IsSet(kubeconfigFlag.Name):
-						apiRes.AuthRequest.Type = KubernetesConfigType.kubeconfigPath
-						apiRes.AuthRequest.KubeconfigPath = kubeconfigFlagValue
-						ssar, errInit := authInit(&apiRes)
-						if errInit != nil {
-							return errInit
-						}
-						apiRes.AuthState = true
-						apiRes.SSAR = ssar
-					case apiRes.CliContext.IsSet(kubeAccessTokenFlag.Name):
-						apiRes.AuthRequest.Type = KubernetesConfigType.accessToken
-						apiRes.AuthRequest.MasterURL = kubeMasterURLFlagValue
-						apiRes.AuthRequest.AccessToken = kubeAccessTokenFlagValue
-						apiRes.AuthRequest.TLSInsecure = true
-						ssar, errInit := authInit(&apiRes)
-						if errInit != nil {
-							return errInit
-						}
-						apiRes.AuthState = true
-						apiRes.SSAR = ssar
-					case apiRes.CliContext.IsSet(kubeInClusterConfigFlag.Name):
-						apiRes.AuthRequest.Type = KubernetesConfigType.inClusterConfig
-						ssar, errInit := authInit(&apiRes)
+					case ctx.IsSet(kubeconfigFlag.Name) || ctx.IsSet(kubeContextFlag.Name):
+						ar.AuthRequest.Type = KubernetesConfigType.kubeconfigPath
+						ar.AuthRequest.KubeconfigPath = kubeconfigFlagValue
+						ar.AuthRequest.Context = kubeContextFlagValue
+					case ctx.IsSet(kubeAccessTokenFlag.Name):
+						ar.AuthRequest.Type = KubernetesConfigType.accessToken
+						ar.AuthRequest.MasterURL = kubeMasterURLFlagValue
+						ar.AuthRequest.AccessToken = kubeAccessTokenFlagValue
+						ar.AuthRequest.TLSInsecure = true
+					case ctx.IsSet(kubeInClusterConfigFlag.Name):
+						ar.AuthRequest.Type = KubernetesConfigType.inClusterConfig
+					}
+
+					if ar.AuthRequest.Type != "" {
+						ssar, errInit := authInit(ar)
 						if errInit != nil {
 							return errInit
 						}
-						apiRes.AuthState = true
-						apiRes.SSAR = ssar
+						ar.AuthState = true
+						ar.SSAR = ssar
+						authSessions.Defaults.Set(defaultClusterID, ar)
 					}
 
 					e := echo.New()
@@ -452,26 +847,26 @@ func main() {
 					fs := echo.MustSubFS(files, "frontend/dist")
 					e.StaticFS("/", fs)
 
-					e.POST("/srv/auth", apiRes.Auth)
-					e.GET("/srv/auth/state", func(c echo.Context) error {
-						var kubeHost string
-						if apiRes.Config != nil {
-							kubeHost = apiRes.Config.Host
-						}
-						return c.JSON(http.StatusOK, AuthResponse{
-							State: apiRes.AuthState, KubeHost: kubeHost, AccessReview: apiRes.SSAR,
-						},
-						)
-					})
+					go authSessions.ExpireEvery(time.Minute)
+
+					e.POST("/srv/auth", authSessions.SessionHandler((*ClusterRegistry).Auth))
+					e.GET("/srv/auth/state", authSessions.Handler((*ClusterRegistry).AuthState))
+					e.GET("/srv/clusters", authSessions.Handler((*ClusterRegistry).ListClusters))
+
+					e.POST("/srv/kubeconfig/contexts", authSessions.Handler((*ClusterRegistry).KubeconfigContexts))
+
+					e.GET("/srv/data*", authSessions.Handler((*ClusterRegistry).DataStreamWSHandler))
+					e.GET("/srv/data/ws", authSessions.SessionHandler((*ClusterRegistry).DataStreamWS))
 
-					e.GET("/srv/data*", apiRes.DataStreamWSHandler)
-					e.GET("/srv/data/ws", apiRes.DataStreamWS)
+					e.GET("/srv/shell*", authSessions.Handler((*ClusterRegistry).ShellWSHandler))
+					e.GET("/srv/shell/exec", authSessions.SessionHandler((*ClusterRegistry).ExecShell))
 
-					e.GET("/srv/shell*", apiRes.ShellWSHandler)
-					e.GET("/srv/shell/exec", apiRes.ExecShell)
+					e.GET("/srv/logs*", authSessions.Handler((*ClusterRegistry).LogsWSHandler))
+					e.GET("/srv/logs/stream", authSessions.SessionHandler((*ClusterRegistry).StreamLogs))
+					e.GET("/srv/logs/download", authSessions.Handler((*ClusterRegistry).DownloadLogs))
 
-					e.GET("/srv/logs*", apiRes.LogsWSHandler)
-					e.GET("/srv/logs/stream", apiRes.StreamLogs)
+					e.POST("/srv/helm/charts", authSessions.Handler((*ClusterRegistry).UploadChart))
+					e.GET("/srv/helm/charts/:ref", authSessions.Handler((*ClusterRegistry).DownloadChart))
 
 					e.Logger.Fatal(e.Start(":" + portFlagValue))
 
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	authSessionCookie = "lutho_session"
+	maxAuthSessions   = 1000
+)
+
+var errTooManySessions = errors.New("too many auth sessions")
+
+type AuthSession struct {
+	ID       string
+	Clusters *ClusterRegistry
+	Identity *rest.ImpersonationConfig
+	Expires  time.Time
+}
+
+type AuthSessionStore struct {
+	// Defaults holds the clusters configured with the start flags, every new
+	// session starts with its own copy of them.
+	Defaults      *ClusterRegistry
+	Sessions      map[string]*AuthSession
+	TTL           time.Duration
+	Impersonation *Impersonation
+	Lock          sync.Mutex
+}
+
+func (ass *AuthSessionStore) newSession(identity *rest.ImpersonationConfig) (*AuthSession, error) {
+	id, err := genSessionId()
+	if err != nil {
+		return nil, err
+	}
+
+	cr := &ClusterRegistry{
+		CliContext: ass.Defaults.CliContext,
+		Clusters:   make(map[string]*APIResource),
+		SessionID:  id,
+		Identity:   identity,
+	}
+
+	ass.Defaults.Lock.RLock()
+	defer ass.Defaults.Lock.RUnlock()
+	for clusterID, ar := range ass.Defaults.Clusters {
+		if identity != nil {
+			arImp, err := impersonateAPIResource(ar, identity)
+			if err != nil {
+				return nil, err
+			}
+			cr.scopeRegistryConfig(arImp)
+			cr.Clusters[clusterID] = arImp
+			continue
+		}
+
+		arCopy, err := copyAPIResource(ar)
+		if err != nil {
+			return nil, err
+		}
+		cr.scopeRegistryConfig(arCopy)
+		cr.Clusters[clusterID] = arCopy
+	}
+
+	return &AuthSession{ID: id, Clusters: cr, Identity: identity}, nil
+}
+
+func (ass *AuthSessionStore) lookup(c echo.Context, identity *rest.ImpersonationConfig) *AuthSession {
+	ass.Lock.Lock()
+	defer ass.Lock.Unlock()
+
+	cookie, err := c.Cookie(authSessionCookie)
+	if err != nil {
+		return nil
+	}
+
+	s, ok := ass.Sessions[cookie.Value]
+	if ok && time.Now().Before(s.Expires) && sameIdentity(s.Identity, identity) {
+		return s
+	}
+	if ok {
+		ass.drop(s)
+	}
+
+	return nil
+}
+
+// Resolve returns the browser session of the request. A new session is only
+// created when create is set, that is on an explicit auth or a stream bind.
+// Other requests without a session get the defaults as they are, or no
+// clusters at all when impersonating, as the defaults are not impersonated.
+// Those handlers only read the registry.
+func (ass *AuthSessionStore) Resolve(c echo.Context, create bool) (*AuthSession, error) {
+	var identity *rest.ImpersonationConfig
+	if ass.Impersonation != nil && ass.Impersonation.Enabled {
+		id, err := ass.Impersonation.Identity(c)
+		if err != nil {
+			return nil, err
+		}
+		identity = id
+	}
+
+	session := ass.lookup(c, identity)
+	if session == nil && !create {
+		if identity != nil {
+			return &AuthSession{Identity: identity, Clusters: &ClusterRegistry{
+				CliContext: ass.Defaults.CliContext,
+				Clusters:   make(map[string]*APIResource),
+				Identity:   identity,
+			}}, nil
+		}
+		return &AuthSession{Clusters: ass.Defaults}, nil
+	}
+	if session == nil {
+		if !ass.reserve() {
+			return nil, errTooManySessions
+		}
+
+		s, err := ass.newSession(identity)
+		if err != nil {
+			return nil, err
+		}
+		session = s
+	}
+
+	ass.Lock.Lock()
+	ass.Sessions[session.ID] = session
+	session.Expires = time.Now().Add(ass.TTL)
+	ass.Lock.Unlock()
+
+	c.SetCookie(&http.Cookie{
+		Name:     authSessionCookie,
+		Value:    session.ID,
+		Path:     "/",
+		Expires:  session.Expires,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return session, nil
+}
+
+// reserve reports whether there is room for another session, expired
+// sessions are dropped first.
+func (ass *AuthSessionStore) reserve() bool {
+	ass.Lock.Lock()
+	defer ass.Lock.Unlock()
+	if len(ass.Sessions) < maxAuthSessions {
+		return true
+	}
+	ass.expire()
+	return len(ass.Sessions) < maxAuthSessions
+}
+
+// Handler resolves the browser session of the request and runs the handler
+// against the clusters of that session.
+func (ass *AuthSessionStore) Handler(h func(*ClusterRegistry, echo.Context) error) echo.HandlerFunc {
+	return ass.handler(h, false)
+}
+
+// SessionHandler is Handler for requests that start a browser session, the
+// auth request and the binds of the streams.
+func (ass *AuthSessionStore) SessionHandler(h func(*ClusterRegistry, echo.Context) error) echo.HandlerFunc {
+	return ass.handler(h, true)
+}
+
+func (ass *AuthSessionStore) handler(h func(*ClusterRegistry, echo.Context) error, create bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		session, err := ass.Resolve(c, create)
+		if errors.Is(err, errUntrustedProxy) || errors.Is(err, errMissingProxyIdentity) {
+			return c.JSON(http.StatusForbidden, APIResourceMessage{
+				Error:      err.Error(),
+				StatusCode: http.StatusForbidden,
+			})
+		}
+		if errors.Is(err, errTooManySessions) {
+			return c.JSON(http.StatusServiceUnavailable, APIResourceMessage{
+				Error:      err.Error(),
+				StatusCode: http.StatusServiceUnavailable,
+			})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, APIResourceMessage{
+				Error:      err.Error(),
+				StatusCode: http.StatusInternalServerError,
+			})
+		}
+
+		return h(session.Clusters, c)
+	}
+}
+
+func (ass *AuthSessionStore) Expire() {
+	ass.Lock.Lock()
+	defer ass.Lock.Unlock()
+	ass.expire()
+}
+
+func (ass *AuthSessionStore) expire() {
+	for _, s := range ass.Sessions {
+		if time.Now().After(s.Expires) {
+			ass.drop(s)
+		}
+	}
+}
+
+// drop removes the session and its registry credentials, the credentials of
+// a proxy user are kept for the next session of the user.
+func (ass *AuthSessionStore) drop(s *AuthSession) {
+	delete(ass.Sessions, s.ID)
+	if s.Identity == nil {
+		os.RemoveAll(filepath.Dir(registryConfigPath(s.ID, nil)))
+	}
+}
+
+func (ass *AuthSessionStore) ExpireEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		ass.Expire()
+	}
+}
@@ -8,6 +8,8 @@ import (
 
 const END_OF_TRANSMISSION = "\u0004"
 
+const defaultFieldManager = "lutho"
+
 var WSCloseCode = struct {
 	info, warning, error uint
 }{
@@ -22,46 +24,109 @@ var WSOpType = struct {
 	rulesReview,
 	list,
 	listAll,
+	watch,
+	unwatch,
 	helmList,
 	get,
 	helmShowValues,
 	helmGet,
 	helmInstall,
 	helmUpgrade,
+	helmDiff,
+	helmTemplate,
+	helmGetManifest,
+	helmValidateValues,
+	helmValuesDiff,
 	helmPull,
 	helmGetTags,
+	helmSearch,
+	helmDependencyUpdate,
+	helmLint,
 	update,
+	create,
+	apply,
+	patch,
+	diff,
 	check,
 	delete,
 	helmUninstall,
+	helmHistory,
+	helmRollback,
+	helmTest,
+	helmStatus,
+	helmJobEvent,
+	helmJobStatus,
+	helmJobCancel,
+	helmRepoAdd,
+	helmRepoList,
+	helmRepoUpdate,
+	helmRepoRemove,
+	helmRegistryLogin,
+	helmRegistryLogout,
+	logFilter,
 	close,
 	stdin,
 	stdout,
 	resize,
 	toast string
 }{
-	bind:           "bind",
-	accessReview:   "accessReview",
-	rulesReview:    "rulesReview",
-	list:           "list",
-	listAll:        "listAll",
-	helmList:       "helmList",
-	get:            "get",
-	helmShowValues: "helmShowValues",
-	helmGet:        "helmGet",
-	helmInstall:    "helmInstall",
-	helmUpgrade:    "helmUpgrade",
-	helmPull:       "helmPull",
-	helmGetTags:    "helmGetTags",
-	check:          "check",
-	update:         "update",
-	delete:         "delete",
-	helmUninstall:  "helmUninstall",
-	close:          "close",
-	stdin:          "stdin",
-	stdout:         "stdout",
-	resize:         "resize",
-	toast:          "toast",
+	bind:                 "bind",
+	accessReview:         "accessReview",
+	rulesReview:          "rulesReview",
+	list:                 "list",
+	listAll:              "listAll",
+	watch:                "watch",
+	unwatch:              "unwatch",
+	helmList:             "helmList",
+	get:                  "get",
+	helmShowValues:       "helmShowValues",
+	helmGet:              "helmGet",
+	helmInstall:          "helmInstall",
+	helmUpgrade:          "helmUpgrade",
+	helmDiff:             "helmDiff",
+	helmTemplate:         "helmTemplate",
+	helmGetManifest:      "helmGetManifest",
+	helmValidateValues:   "helmValidateValues",
+	helmValuesDiff:       "helmValuesDiff",
+	helmPull:             "helmPull",
+	helmGetTags:          "helmGetTags",
+	helmSearch:           "helmSearch",
+	helmDependencyUpdate: "helmDependencyUpdate",
+	helmLint:             "helmLint",
+	check:                "check",
+	update:               "update",
+	create:               "create",
+	apply:                "apply",
+	patch:                "patch",
+	diff:                 "diff",
+	delete:               "delete",
+	helmUninstall:        "helmUninstall",
+	helmHistory:          "helmHistory",
+	helmRollback:         "helmRollback",
+	helmTest:             "helmTest",
+	helmStatus:           "helmStatus",
+	helmJobEvent:         "helmJobEvent",
+	helmJobStatus:        "helmJobStatus",
+	helmJobCancel:        "helmJobCancel",
+	helmRepoAdd:          "helmRepoAdd",
+	helmRepoList:         "helmRepoList",
+	helmRepoUpdate:       "helmRepoUpdate",
+	helmRepoRemove:       "helmRepoRemove",
+	helmRegistryLogin:    "helmRegistryLogin",
+	helmRegistryLogout:   "helmRegistryLogout",
+	close:                "close",
+	stdin:                "stdin",
+	stdout:               "stdout",
+	resize:               "resize",
+	toast:                "toast",
+}
+
+var DiffStrategy = struct {
+	apply,
+	update string
+}{
+	apply:  "apply",
+	update: "update",
 }
 
 var KubernetesConfigType = struct {